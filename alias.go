@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// ErrInvalidAlias is returned for codes rejected by strict alias checks.
+var ErrInvalidAlias = errors.New("invalid alias")
+
+// confusableScripts are scripts with letters that look alike; a code mixing
+// them is most likely an impersonation attempt.
+var confusableScripts = []*unicode.RangeTable{unicode.Latin, unicode.Cyrillic, unicode.Greek}
+
+// AliasNormalizer maps visually identical codes to the same key.
+type AliasNormalizer struct {
+	// foldCase makes codes case-insensitive.
+	foldCase bool
+	// strict rejects control characters and codes mixing confusable
+	// scripts.
+	strict bool
+}
+
+func (n AliasNormalizer) Normalize(alias string) (string, error) {
+	alias = norm.NFC.String(alias)
+	if n.foldCase {
+		alias = cases.Fold().String(alias)
+	}
+	if !n.strict {
+		return alias, nil
+	}
+
+	var script *unicode.RangeTable
+	for _, r := range alias {
+		if unicode.IsControl(r) {
+			return "", fmt.Errorf("%w: contains control characters", ErrInvalidAlias)
+		}
+		for _, s := range confusableScripts {
+			if !unicode.Is(s, r) {
+				continue
+			}
+			if script != nil && script != s {
+				return "", fmt.Errorf("%w: mixes scripts", ErrInvalidAlias)
+			}
+			script = s
+		}
+	}
+	return alias, nil
+}
+
+// NormalizingStore applies an AliasNormalizer to every code before handing
+// it to the wrapped store.
+type NormalizingStore struct {
+	store      Store
+	normalizer AliasNormalizer
+}
+
+func (s *NormalizingStore) Add(shortenedURL string, link Link) error {
+	code, err := s.normalizer.Normalize(shortenedURL)
+	if err != nil {
+		return err
+	}
+	return s.store.Add(code, link)
+}
+
+func (s *NormalizingStore) AddBatch(records []LinkRecord) []error {
+	errs := make([]error, len(records))
+	var normalized []LinkRecord
+	var indexes []int
+	for i, rec := range records {
+		code, err := s.normalizer.Normalize(rec.Hash)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		rec.Hash = code
+		normalized = append(normalized, rec)
+		indexes = append(indexes, i)
+	}
+	for j, err := range addBatch(s.store, normalized) {
+		errs[indexes[j]] = err
+	}
+	return errs
+}
+
+func (s *NormalizingStore) Remove(shortenedURL string) error {
+	code, err := s.normalizer.Normalize(shortenedURL)
+	if err != nil {
+		return err
+	}
+	return s.store.Remove(code)
+}
+
+func (s *NormalizingStore) Get(shortenedURL string) (Link, error) {
+	code, err := s.normalizer.Normalize(shortenedURL)
+	if err != nil {
+		return Link{}, err
+	}
+	return s.store.Get(code)
+}
+
+func (s *NormalizingStore) GetByURL(longURL string) ([]string, error) {
+	return s.store.GetByURL(longURL)
+}
+
+func (s *NormalizingStore) List(limit, offset int) ([]LinkRecord, error) {
+	return s.store.List(limit, offset)
+}
+
+func (s *NormalizingStore) ListTagged(tags []string, all bool, limit, offset int) ([]LinkRecord, error) {
+	ts, ok := s.store.(TagStore)
+	if !ok {
+		return nil, fmt.Errorf("store cannot filter by tag")
+	}
+	return ts.ListTagged(tags, all, limit, offset)
+}
+
+func (s *NormalizingStore) TagCounts() (map[string]int, error) {
+	ts, ok := s.store.(TagStore)
+	if !ok {
+		return nil, fmt.Errorf("store cannot list tags")
+	}
+	return ts.TagCounts()
+}
+
+func (s *NormalizingStore) Rewrite(fn func(code, longURL string) (string, bool, error), dryRun bool) ([]rewriteChange, error) {
+	rw, ok := s.store.(Rewriter)
+	if !ok {
+		return nil, fmt.Errorf("store cannot rewrite destinations")
+	}
+	return rw.Rewrite(fn, dryRun)
+}
+
+func (s *NormalizingStore) Hit(shortenedURL string) error {
+	hc, ok := s.store.(HitCounter)
+	if !ok {
+		return fmt.Errorf("store cannot count hits")
+	}
+	code, err := s.normalizer.Normalize(shortenedURL)
+	if err != nil {
+		return err
+	}
+	return hc.Hit(code)
+}
+
+func (s *NormalizingStore) WasRemoved(shortenedURL string) (bool, error) {
+	ts, ok := s.store.(Tombstoner)
+	if !ok {
+		return false, nil
+	}
+	code, err := s.normalizer.Normalize(shortenedURL)
+	if err != nil {
+		return false, err
+	}
+	return ts.WasRemoved(code)
+}
+
+func (s *NormalizingStore) Len() (int, error) {
+	sz, ok := s.store.(Sizer)
+	if !ok {
+		return 0, fmt.Errorf("store cannot report its size")
+	}
+	return sz.Len()
+}
+
+func NewNormalizingStore(store Store, normalizer AliasNormalizer) *NormalizingStore {
+	return &NormalizingStore{store: store, normalizer: normalizer}
+}
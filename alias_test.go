@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestNormalizingStore(t *testing.T) {
+	const (
+		cafeNFC = "caf\u00e9"  // é as one code point
+		cafeNFD = "cafe\u0301" // e followed by a combining acute accent
+	)
+	tests := []struct {
+		name       string
+		normalizer AliasNormalizer
+		add        string
+		get        string
+		wantErr    error
+	}{
+		{name: "NFD finds NFC", add: cafeNFC, get: cafeNFD},
+		{name: "NFC finds NFD", add: cafeNFD, get: cafeNFC},
+		{name: "case kept by default", add: "Promo", get: "promo", wantErr: ErrNotFound},
+		{name: "case folded", normalizer: AliasNormalizer{foldCase: true}, add: "Promo", get: "PROMO"},
+		{name: "unicode case folded", normalizer: AliasNormalizer{foldCase: true}, add: "Café", get: "CAFÉ"},
+		{name: "strict allows one script", normalizer: AliasNormalizer{strict: true}, add: "промо", get: "промо"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewNormalizingStore(NewMemoryStore(), tt.normalizer)
+			mustAdd(t, s, tt.add, "https://example.com/")
+			_, err := s.Get(tt.get)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Get(%q) error = %v, want %v", tt.get, err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if err := s.Add(tt.get, Link{URL: "https://example.org/"}); !errors.Is(err, ErrAlreadyExists) {
+				t.Errorf("adding variant %q: error = %v, want %v", tt.get, err, ErrAlreadyExists)
+			}
+			if err := s.Remove(tt.get); err != nil {
+				t.Errorf("removing variant %q: %v", tt.get, err)
+			}
+		})
+	}
+}
+
+func TestAliasNormalizerStrict(t *testing.T) {
+	tests := []struct {
+		name    string
+		strict  bool
+		alias   string
+		wantErr bool
+	}{
+		{name: "plain ascii", strict: true, alias: "promo-2024"},
+		{name: "control character", strict: true, alias: "pro\u0000mo", wantErr: true},
+		{name: "latin with cyrillic o", strict: true, alias: "pr\u043emo", wantErr: true},
+		{name: "latin with greek omicron", strict: true, alias: "pr\u03bfmo", wantErr: true},
+		{name: "mixed scripts allowed when not strict", alias: "pr\u043emo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := AliasNormalizer{strict: tt.strict}.Normalize(tt.alias)
+			if tt.wantErr != errors.Is(err, ErrInvalidAlias) {
+				t.Errorf("error = %v, want invalid alias %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAddPathNormalizedAlias(t *testing.T) {
+	const (
+		cafeNFC = "caf\u00e9"
+		cafeNFD = "cafe\u0301"
+	)
+	tests := []struct {
+		name       string
+		normalizer AliasNormalizer
+		first      string
+		second     string
+		wantStatus int
+	}{
+		{name: "NFD after NFC", first: cafeNFC, second: cafeNFD, wantStatus: http.StatusConflict},
+		{name: "NFC after NFD", first: cafeNFD, second: cafeNFC, wantStatus: http.StatusConflict},
+		{name: "case kept by default", first: "Café", second: "café", wantStatus: http.StatusCreated},
+		{name: "case folded", normalizer: AliasNormalizer{foldCase: true}, first: "Café", second: "CAFÉ", wantStatus: http.StatusConflict},
+		{name: "strict one script", normalizer: AliasNormalizer{strict: true}, first: "промо", second: "акция", wantStatus: http.StatusCreated},
+		{name: "strict mixed scripts", normalizer: AliasNormalizer{strict: true}, first: "promo", second: "pr\u043emo", wantStatus: http.StatusBadRequest},
+		{name: "mixed scripts when not strict", first: "promo", second: "pr\u043emo", wantStatus: http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewNormalizingStore(NewMemoryStore(), tt.normalizer)
+			add := newTestAddPath(store)
+			for i, alias := range []string{tt.first, tt.second} {
+				body, _ := json.Marshal(map[string]string{"url": "https://example.com/", "alias": alias})
+				rec := do(add, "POST", "/add", string(body))
+				want := http.StatusCreated
+				if i == 1 {
+					want = tt.wantStatus
+				}
+				if rec.Code != want {
+					t.Fatalf("adding %q: status = %v, want %v: %s", alias, rec.Code, want, rec.Body)
+				}
+			}
+			if _, err := store.Get(tt.first); err != nil {
+				t.Errorf("Get(%q): %v", tt.first, err)
+			}
+		})
+	}
+}
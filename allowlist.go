@@ -0,0 +1,44 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// AllowPatterns is a repeatable flag of regular expressions; when any are
+// configured a destination must match at least one of them. Patterns are
+// anchored and must match the whole URL, so "https://example\.com/.*"
+// allows that site but not "https://evil.com/?x=https://example.com/".
+type AllowPatterns []*regexp.Regexp
+
+func (ps *AllowPatterns) String() string {
+	var parts []string
+	for _, p := range *ps {
+		parts = append(parts, p.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+// Set compiles pattern, so a bad expression fails flag parsing at startup.
+func (ps *AllowPatterns) Set(pattern string) error {
+	re, err := regexp.Compile(`^(?:` + pattern + `)$`)
+	if err != nil {
+		return err
+	}
+	*ps = append(*ps, re)
+	return nil
+}
+
+// Allowed reports whether longURL may be shortened. An empty list allows
+// everything.
+func (ps AllowPatterns) Allowed(longURL string) bool {
+	if len(ps) == 0 {
+		return true
+	}
+	for _, re := range ps {
+		if re.MatchString(longURL) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestAllowPatterns(t *testing.T) {
+	var ps AllowPatterns
+	for _, p := range []string{`https://example\.com/.*`, `https://docs\.example\.org/guides/[a-z-]+`} {
+		if err := ps.Set(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		url        string
+		wantStatus int
+	}{
+		{url: "https://example.com/", wantStatus: http.StatusCreated},
+		{url: "https://example.com/a/b?c=d", wantStatus: http.StatusCreated},
+		{url: "https://docs.example.org/guides/getting-started", wantStatus: http.StatusCreated},
+		{url: "https://docs.example.org/guides/getting-started/more", wantStatus: http.StatusForbidden},
+		{url: "https://docs.example.org/blog/post", wantStatus: http.StatusForbidden},
+		{url: "https://evil.com/", wantStatus: http.StatusForbidden},
+		{url: "https://evil.com/?x=https://example.com/", wantStatus: http.StatusForbidden},
+		{url: "https://example.com.evil.com/", wantStatus: http.StatusForbidden},
+		{url: "http://example.com/", wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			a := newTestAddPath(NewMemoryStore())
+			a.allowPatterns = ps
+			body, _ := json.Marshal(map[string]string{"url": tt.url})
+			rec := do(a, "POST", "/add", string(body))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %v, want %v: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+}
+
+func TestAllowPatternsUpgradedScheme(t *testing.T) {
+	var ps AllowPatterns
+	ps.Set(`https://example\.com/.*`)
+	a := newTestAddPath(NewMemoryStore())
+	a.allowPatterns = ps
+	a.upgradeHTTP = true
+	rec := do(a, "POST", "/add", `{"url": "http://example.com/"}`)
+	if rec.Code != http.StatusCreated {
+		t.Errorf("status = %v, want %v: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+}
+
+func TestAllowPatternsEmptyAllowsAll(t *testing.T) {
+	if !(AllowPatterns{}).Allowed("https://anything.example/") {
+		t.Error("an empty allowlist should allow every destination")
+	}
+}
+
+func TestAllowPatternsBadPattern(t *testing.T) {
+	var ps AllowPatterns
+	for _, p := range []string{`https://(example\.com`, `[`, `a**`} {
+		if err := ps.Set(p); err == nil {
+			t.Errorf("%q: expected an error", p)
+		}
+	}
+	if len(ps) != 0 {
+		t.Errorf("bad patterns were added: %v", ps.String())
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// Click is one followed link as seen by an AnalyticsStore.
+type Click struct {
+	Code         string
+	Time         time.Time
+	ReferrerHost string
+}
+
+// AnalyticsStore keeps click analytics apart from the code→URL mappings
+// in Store, so the redirect path never has to load or rewrite them.
+type AnalyticsStore interface {
+	RecordClick(c Click) error
+}
+
+// otherBucket collects referrers once a link has maxBuckets distinct ones.
+const otherBucket = "(other)"
+
+type linkAnalytics struct {
+	Total     int            `json:"total"`
+	Days      map[string]int `json:"days"`
+	Referrers map[string]int `json:"referrers"`
+}
+
+type analyticsFile struct {
+	Version string                    `json:"version"`
+	Links   map[string]*linkAnalytics `json:"links"`
+}
+
+// FileAnalyticsStore aggregates clicks per link, per day and per referrer
+// host in memory and saves them to their own file every flushInterval and
+// on Close. Each link keeps at most maxBuckets referrers; the rest are
+// counted under otherBucket so the file stays bounded.
+type FileAnalyticsStore struct {
+	filename   string
+	maxBuckets int
+
+	mu    sync.Mutex
+	links map[string]*linkAnalytics
+	dirty bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func (s *FileAnalyticsStore) RecordClick(c Click) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	la, ok := s.links[c.Code]
+	if !ok {
+		la = &linkAnalytics{Days: map[string]int{}, Referrers: map[string]int{}}
+		s.links[c.Code] = la
+	}
+	la.Total++
+	la.Days[c.Time.UTC().Format("2006-01-02")]++
+	if c.ReferrerHost != "" {
+		ref := c.ReferrerHost
+		if _, seen := la.Referrers[ref]; !seen && len(la.Referrers) >= s.maxBuckets {
+			ref = otherBucket
+		}
+		la.Referrers[ref]++
+	}
+	s.dirty = true
+	return nil
+}
+
+func (s *FileAnalyticsStore) flush() error {
+	s.mu.Lock()
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	raw, err := json.Marshal(analyticsFile{Version: "1.0", Links: s.links})
+	s.dirty = false
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(s.filename, raw, nil)
+	if err != nil {
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+	}
+	return err
+}
+
+func (s *FileAnalyticsStore) run(flushInterval time.Duration) {
+	defer close(s.done)
+	t := time.NewTicker(flushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			err := s.flush()
+			if err != nil {
+				log.Printf("analytics: unable to save %v: %v", s.filename, err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Close stops the background flush and saves any pending clicks.
+func (s *FileAnalyticsStore) Close() error {
+	close(s.stop)
+	<-s.done
+	return s.flush()
+}
+
+func NewFileAnalyticsStore(filename string, flushInterval time.Duration, maxBuckets int) (*FileAnalyticsStore, error) {
+	if flushInterval <= 0 {
+		return nil, fmt.Errorf("analytics flush interval must be positive")
+	}
+	s := &FileAnalyticsStore{
+		filename:   filename,
+		maxBuckets: maxBuckets,
+		links:      map[string]*linkAnalytics{},
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	raw, err := os.ReadFile(filename)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		var af analyticsFile
+		err = json.Unmarshal(raw, &af)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse analytics file %v: %v", filename, err)
+		}
+		if af.Links != nil {
+			s.links = af.Links
+		}
+	}
+	go s.run(flushInterval)
+	return s, nil
+}
+
+// recordClick hands the click to the analytics store without holding up
+// the redirect.
+func (p *RedirectPath) recordClick(e Event) {
+	if p.analytics == nil {
+		return
+	}
+	c := Click{Code: e.Code, Time: e.Time}
+	if u, err := url.Parse(e.Referer); err == nil {
+		c.ReferrerHost = u.Hostname()
+	}
+	go func() {
+		err := p.analytics.RecordClick(c)
+		if err != nil {
+			log.Printf("analytics: unable to record click on %v: %v", c.Code, err)
+		}
+	}()
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// waitForClicks polls s until code has total clicks, since RedirectPath
+// records them in the background.
+func waitForClicks(t *testing.T, s *FileAnalyticsStore, code string, total int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		s.mu.Lock()
+		la := s.links[code]
+		done := la != nil && la.Total == total
+		s.mu.Unlock()
+		if done {
+			return
+		}
+	}
+	t.Fatalf("%v never reached %v clicks", code, total)
+}
+
+func readAnalytics(t *testing.T, filename string) analyticsFile {
+	t.Helper()
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var af analyticsFile
+	if err := json.Unmarshal(raw, &af); err != nil {
+		t.Fatal(err)
+	}
+	return af
+}
+
+func TestRedirectRecordsAnalyticsSeparately(t *testing.T) {
+	store := newTestFileStore(t, 0)
+	mustAdd(t, store, "abc", "https://example.com/")
+	before, err := os.ReadFile(store.filenane)
+	if err != nil {
+		t.Fatal(err)
+	}
+	analyticsName := filepath.Join(t.TempDir(), "analytics.json")
+	analytics, err := NewFileAnalyticsStore(analyticsName, time.Hour, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &RedirectPath{store: store, redirectStatus: http.StatusFound, analytics: analytics}
+	referers := []string{
+		"https://a.example/1", "https://a.example/2", "https://b.example/",
+		"https://c.example/", "https://d.example/", "",
+	}
+	for _, ref := range referers {
+		r := httptest.NewRequest("GET", "/abc", nil)
+		r = mux.SetURLVars(r, map[string]string{"hash": "abc"})
+		if ref != "" {
+			r.Header.Set("Referer", ref)
+		}
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, r)
+		if rec.Code != http.StatusFound {
+			t.Fatalf("status = %v", rec.Code)
+		}
+	}
+	waitForClicks(t, analytics, "abc", len(referers))
+	if err := analytics.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	la := readAnalytics(t, analyticsName).Links["abc"]
+	if la == nil {
+		t.Fatal("no analytics saved for abc")
+	}
+	// Clicks are recorded in the background, so which two referrers get
+	// their own bucket depends on arrival order.
+	referred := 0
+	for _, n := range la.Referrers {
+		referred += n
+	}
+	tests := []struct {
+		name      string
+		got, want int
+	}{
+		{name: "total", got: la.Total, want: len(referers)},
+		{name: "today", got: la.Days[time.Now().UTC().Format("2006-01-02")], want: len(referers)},
+		{name: "referred clicks", got: referred, want: len(referers) - 1},
+		{name: "referrer buckets", got: len(la.Referrers), want: 3},
+		{name: "past the referrer cap", got: min(la.Referrers[otherBucket], 1), want: 1},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%v = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+
+	after, err := os.ReadFile(store.filenane)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Errorf("redirects rewrote the link store:\n%s", after)
+	}
+}
+
+func TestFileAnalyticsStoreReload(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "analytics.json")
+	for round := 1; round <= 3; round++ {
+		s, err := NewFileAnalyticsStore(filename, time.Hour, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.RecordClick(Click{Code: "abc", Time: time.Now(), ReferrerHost: fmt.Sprintf("r%v.example", round)})
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+		la := readAnalytics(t, filename).Links["abc"]
+		if la == nil || la.Total != round || len(la.Referrers) != round {
+			t.Fatalf("round %v: got %+v", round, la)
+		}
+	}
+}
+
+func TestFileAnalyticsStoreInvalid(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "analytics.json")
+	os.WriteFile(filename, []byte("not json"), 0644)
+	if _, err := NewFileAnalyticsStore(filename, time.Hour, 10); err == nil {
+		t.Error("expected an error for a corrupt analytics file")
+	}
+	if _, err := NewFileAnalyticsStore(filepath.Join(t.TempDir(), "new.json"), 0, 10); err == nil {
+		t.Error("expected an error for a zero flush interval")
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Scopes an API key can be granted.
+const (
+	scopeCreate    = "create"
+	scopeDelete    = "delete"
+	scopeAdmin     = "admin"
+	scopeReadStats = "read-stats"
+)
+
+var knownScopes = map[string]bool{
+	scopeCreate:    true,
+	scopeDelete:    true,
+	scopeAdmin:     true,
+	scopeReadStats: true,
+}
+
+// APIKeys maps each configured key to the scopes it grants. With no keys
+// configured every endpoint is open.
+type APIKeys map[string]map[string]bool
+
+func (k APIKeys) String() string {
+	var keys []string
+	for key := range k {
+		keys = append(keys, key[:min(4, len(key))]+"…")
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+// Set parses "key=scope1,scope2".
+func (k APIKeys) Set(value string) error {
+	key, rawScopes, ok := strings.Cut(value, "=")
+	if !ok || key == "" || rawScopes == "" {
+		return fmt.Errorf("API key %q must look like key=scope1,scope2", value)
+	}
+	scopes := make(map[string]bool)
+	for _, scope := range strings.Split(rawScopes, ",") {
+		if !knownScopes[scope] {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+		scopes[scope] = true
+	}
+	k[key] = scopes
+	return nil
+}
+
+// Grants reports whether any configured key has scope.
+func (k APIKeys) Grants(scope string) bool {
+	for _, scopes := range k {
+		if scopes[scope] {
+			return true
+		}
+	}
+	return false
+}
+
+// Require wraps next so it only runs for requests carrying a key with
+// scope. The admin scope grants every other scope.
+func (k APIKeys) Require(scope string, next http.Handler) http.Handler {
+	if len(k) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scopes, ok := k[requestAPIKey(r)]
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, http.StatusUnauthorized, "missing or unknown API key")
+			return
+		}
+		if !scopes[scope] && !scopes[scopeAdmin] {
+			writeJSONError(w, http.StatusForbidden, fmt.Sprintf("API key lacks the %q scope", scope))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	auth := r.Header.Get("Authorization")
+	if strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
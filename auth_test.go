@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestAPIKeys(t *testing.T, values ...string) APIKeys {
+	t.Helper()
+	keys := APIKeys{}
+	for _, v := range values {
+		if err := keys.Set(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return keys
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	keys := newTestAPIKeys(t,
+		"creator=create",
+		"deleter=delete",
+		"reader=read-stats",
+		"ops=create,read-stats",
+		"root=admin",
+	)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	endpoints := map[string]http.Handler{
+		scopeCreate:    keys.Require(scopeCreate, ok),
+		scopeDelete:    keys.Require(scopeDelete, ok),
+		scopeReadStats: keys.Require(scopeReadStats, ok),
+		scopeAdmin:     keys.Require(scopeAdmin, ok),
+	}
+	tests := []struct {
+		key     string
+		allowed []string
+	}{
+		{key: "creator", allowed: []string{scopeCreate}},
+		{key: "deleter", allowed: []string{scopeDelete}},
+		{key: "reader", allowed: []string{scopeReadStats}},
+		{key: "ops", allowed: []string{scopeCreate, scopeReadStats}},
+		{key: "root", allowed: []string{scopeCreate, scopeDelete, scopeReadStats, scopeAdmin}},
+	}
+	for _, tt := range tests {
+		allowed := make(map[string]bool)
+		for _, s := range tt.allowed {
+			allowed[s] = true
+		}
+		for scope, h := range endpoints {
+			t.Run(tt.key+"/"+scope, func(t *testing.T) {
+				want := http.StatusForbidden
+				if allowed[scope] {
+					want = http.StatusNoContent
+				}
+				r := httptest.NewRequest("GET", "/", nil)
+				r.Header.Set("X-API-Key", tt.key)
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, r)
+				if rec.Code != want {
+					t.Errorf("status = %v, want %v", rec.Code, want)
+				}
+			})
+		}
+	}
+}
+
+func TestAPIKeyCredentials(t *testing.T) {
+	keys := newTestAPIKeys(t, "creator=create")
+	h := keys.Require(scopeCreate, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		name       string
+		header     string
+		value      string
+		wantStatus int
+	}{
+		{name: "x-api-key", header: "X-API-Key", value: "creator", wantStatus: http.StatusOK},
+		{name: "bearer", header: "Authorization", value: "Bearer creator", wantStatus: http.StatusOK},
+		{name: "missing", wantStatus: http.StatusUnauthorized},
+		{name: "unknown", header: "X-API-Key", value: "nobody", wantStatus: http.StatusUnauthorized},
+		{name: "basic auth", header: "Authorization", value: "Basic creator", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/add", nil)
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %v, want %v", rec.Code, tt.wantStatus)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without WWW-Authenticate")
+			}
+		})
+	}
+}
+
+func TestAPIKeysOpenWithoutKeys(t *testing.T) {
+	h := APIKeys{}.Require(scopeAdmin, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/admin/check-links", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %v, want %v", rec.Code, http.StatusOK)
+	}
+}
+
+func TestAPIKeysSet(t *testing.T) {
+	for _, v := range []string{"", "key", "key=", "=create", "key=create,write", "key=Create"} {
+		if err := (APIKeys{}).Set(v); err == nil {
+			t.Errorf("%q: expected an error", v)
+		}
+	}
+}
+
+func TestAPIKeysGrants(t *testing.T) {
+	keys := newTestAPIKeys(t, "creator=create", "reader=read-stats")
+	tests := map[string]bool{
+		scopeCreate:    true,
+		scopeReadStats: true,
+		scopeDelete:    false,
+		scopeAdmin:     false,
+	}
+	for scope, want := range tests {
+		if got := keys.Grants(scope); got != want {
+			t.Errorf("Grants(%v) = %v, want %v", scope, got, want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// maxBulkItems bounds a single POST /add/bulk request.
+const maxBulkItems = 10000
+
+// BatchStore is implemented by stores that can add many links in one
+// operation. The returned slice holds one error (or nil) per record.
+type BatchStore interface {
+	AddBatch(records []LinkRecord) []error
+}
+
+// addBatch adds records through store's BatchStore implementation, or one
+// at a time when it has none.
+func addBatch(store Store, records []LinkRecord) []error {
+	if bs, ok := store.(BatchStore); ok {
+		return bs.AddBatch(records)
+	}
+	errs := make([]error, len(records))
+	for i, rec := range records {
+		errs[i] = store.Add(rec.Hash, rec.Link)
+	}
+	return errs
+}
+
+// BulkAddPath shortens many destinations in one request, applying the same
+// rules as AddPath to each. One bad entry does not fail the others.
+type BulkAddPath struct {
+	add *AddPath
+}
+
+func (p *BulkAddPath) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	type bulkItem struct {
+		URL   string `json:"url"`
+		Alias string `json:"alias"`
+	}
+	type bulkResult struct {
+		Index        int    `json:"index"`
+		ShortenedURL string `json:"shortened_url,omitempty"`
+		LongURL      string `json:"long_url,omitempty"`
+		Error        string `json:"error,omitempty"`
+	}
+
+	var items []bulkItem
+	err := json.NewDecoder(r.Body).Decode(&items)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("unable to parse request: %v", err))
+		return
+	}
+	if len(items) > maxBulkItems {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("at most %v items may be added at once", maxBulkItems))
+		return
+	}
+
+	results := make([]bulkResult, len(items))
+	var records []LinkRecord
+	// indexes maps each record back to its position in items.
+	var indexes []int
+	for i, item := range items {
+		results[i].Index = i
+		hash, longURL, existing, err := p.prepare(item.URL, item.Alias)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		if existing {
+			results[i].ShortenedURL = shortURL(p.add.domain, hash)
+			results[i].LongURL = longURL
+			continue
+		}
+		records = append(records, LinkRecord{Hash: hash, Link: Link{URL: longURL}})
+		indexes = append(indexes, i)
+	}
+
+	errs := addBatch(p.add.store, records)
+	for j, rec := range records {
+		i := indexes[j]
+		hash, created, err := rec.Hash, errs[j] == nil, errs[j]
+		// A generated code may collide with an existing link; resolve
+		// that the way AddPath does, reusing or extending the code.
+		if errors.Is(err, ErrAlreadyExists) && items[i].Alias == "" {
+			hash, created, err = p.add.addGenerated(rec.Hash, rec.Link)
+		}
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		if created {
+			p.add.hooks.Add(newEvent(r, hash, rec.URL))
+		}
+		results[i].ShortenedURL = shortURL(p.add.domain, hash)
+		results[i].LongURL = rec.URL
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// prepare validates one bulk entry and returns the code and destination
+// it should be stored under. existing is set when the duplicate policy
+// reuses a link already stored for the destination.
+func (p *BulkAddPath) prepare(longURL, alias string) (hash string, dest string, existing bool, err error) {
+	if longURL == "" {
+		return "", "", false, requestError("url is empty")
+	}
+	if alias != "" && !validAlias(alias) {
+		return "", "", false, requestError("alias may only contain letters, digits, '-' and '_', and must not be a reserved path")
+	}
+	longURL, err = p.add.destination(longURL)
+	if err != nil {
+		return "", "", false, err
+	}
+	hash, found, existing, err := p.add.existing(alias, Link{URL: longURL})
+	if err != nil {
+		return "", "", false, err
+	}
+	if existing {
+		return hash, found.URL, true, nil
+	}
+	if alias != "" {
+		return alias, longURL, false, nil
+	}
+	hash, err = p.add.code(longURL, 0)
+	if err != nil {
+		return "", "", false, err
+	}
+	return hash, longURL, false, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type bulkResult struct {
+	Index        int    `json:"index"`
+	ShortenedURL string `json:"shortened_url"`
+	LongURL      string `json:"long_url"`
+	Error        string `json:"error"`
+}
+
+func TestBulkAddPath(t *testing.T) {
+	const body = `[
+		{"url": "https://example.com/a"},
+		{"url": "https://example.com/a"},
+		{"url": "https://example.com/b", "alias": "docs"},
+		{"url": "https://example.com/c", "alias": "docs"},
+		{"url": "https://example.com/d", "alias": "taken"},
+		{"url": "https://example.com/e", "alias": "a b"},
+		{"url": ""},
+		{"url": "https://example.com/existing"},
+		{"url": "enc:c2VjcmV0LWRlc3RpbmF0aW9uLWNpcGhlcnRleHQtYnl0ZXM"}
+	]`
+	stores := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store { return NewMemoryStore() },
+		"file":   func(t *testing.T) Store { return newTestFileStore(t, 0) },
+	}
+	tests := []struct {
+		name   string
+		policy DuplicatePolicy
+		// want lists, per item, the expected code or "!" plus part of the
+		// expected error.
+		want []string
+	}{
+		{
+			name:   "allow",
+			policy: DuplicatesAllow,
+			want:   []string{"=a", "=a", "docs", "!exists", "!exists", "!alias", "!empty", "=existing", "!reserved"},
+		},
+		{
+			name:   "dedupe",
+			policy: DuplicatesDedupe,
+			want:   []string{"=a", "=a", "docs", "!exists", "!exists", "!alias", "!empty", "old", "!reserved"},
+		},
+		{
+			name:   "reject",
+			policy: DuplicatesReject,
+			want:   []string{"=a", "=a", "docs", "!exists", "!exists", "!alias", "!empty", "!already has", "!reserved"},
+		},
+	}
+	for storeName, newStore := range stores {
+		for _, tt := range tests {
+			t.Run(storeName+"/"+tt.name, func(t *testing.T) {
+				store := newStore(t)
+				mustAdd(t, store, "taken", "https://example.org/")
+				mustAdd(t, store, "old", "https://example.com/existing")
+				a := newTestAddPath(store)
+				a.duplicates = tt.policy
+				// "=url" stands for the code generated for that path.
+				generated := func(path string) string {
+					code, err := a.generator.Generate("https://example.com/" + path)
+					if err != nil {
+						t.Fatal(err)
+					}
+					return code
+				}
+
+				rec := do(&BulkAddPath{add: a}, "POST", "/add/bulk", body)
+				if rec.Code != http.StatusOK {
+					t.Fatalf("status = %v: %s", rec.Code, rec.Body)
+				}
+				var results []bulkResult
+				if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+					t.Fatal(err)
+				}
+				if len(results) != len(tt.want) {
+					t.Fatalf("got %v results, want %v", len(results), len(tt.want))
+				}
+				for i, want := range tt.want {
+					got := results[i]
+					if got.Index != i {
+						t.Errorf("result %v has index %v", i, got.Index)
+					}
+					if strings.HasPrefix(want, "!") {
+						if got.Error == "" || !strings.Contains(got.Error, want[1:]) || got.ShortenedURL != "" {
+							t.Errorf("item %v: got %+v, want an error about %q", i, got, want[1:])
+						}
+						continue
+					}
+					if strings.HasPrefix(want, "=") {
+						want = generated(want[1:])
+					}
+					if got.Error != "" || got.ShortenedURL != "https://sho.rt/"+want {
+						t.Errorf("item %v: got %+v, want code %v", i, got, want)
+						continue
+					}
+					link, err := store.Get(want)
+					if err != nil || link.URL != got.LongURL {
+						t.Errorf("item %v: %v stores %q, %v", i, want, link.URL, err)
+					}
+				}
+				if link, _ := store.Get("taken"); link.URL != "https://example.org/" {
+					t.Errorf("taken was overwritten with %q", link.URL)
+				}
+			})
+		}
+	}
+}
+
+func TestBulkAddPathRejectsRequest(t *testing.T) {
+	tooMany := "[" + strings.Repeat(`{"url": "https://example.com/"},`, maxBulkItems) + `{"url": "https://example.com/"}]`
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "malformed", body: `[{"url": }]`},
+		{name: "not an array", body: `{"url": "https://example.com/"}`},
+		{name: "too many items", body: tooMany},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			rec := do(&BulkAddPath{add: newTestAddPath(store)}, "POST", "/add/bulk", tt.body)
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %v, want %v", rec.Code, http.StatusBadRequest)
+			}
+			if n, _ := store.Len(); n != 0 {
+				t.Errorf("rejected request stored %v links", n)
+			}
+		})
+	}
+}
+
+func TestFileStoreAddBatch(t *testing.T) {
+	fs := newTestFileStore(t, 0)
+	mustAdd(t, fs, "taken", "https://example.org/")
+	var records []LinkRecord
+	for i := 0; i < 100; i++ {
+		records = append(records, LinkRecord{Hash: fmt.Sprintf("c%v", i), Link: Link{URL: fmt.Sprintf("https://example.com/%v", i)}})
+	}
+	records = append(records, LinkRecord{Hash: "taken", Link: Link{URL: "https://example.com/"}})
+	errs := fs.AddBatch(records)
+	for i, err := range errs[:100] {
+		if err != nil {
+			t.Errorf("record %v: %v", i, err)
+		}
+	}
+	if errs[100] == nil {
+		t.Error("taken code was accepted")
+	}
+	if n, _ := fs.Len(); n != 101 {
+		t.Errorf("store holds %v links, want 101", n)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// canonicalExempt lists paths that are served on any host, so probes and
+// scrapers hitting an instance directly are not redirected away.
+var canonicalExempt = map[string]bool{
+	"/healthz":            true,
+	"/admin/metrics.json": true,
+}
+
+// CanonicalHost redirects requests arriving on a non-canonical host (or
+// scheme, when the canonical value includes one) to the same path on the
+// canonical host with 301 Moved Permanently.
+type CanonicalHost struct {
+	scheme string
+	host   string
+	next   http.Handler
+}
+
+func (c *CanonicalHost) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if canonicalExempt[r.URL.Path] || c.matches(r) {
+		c.next.ServeHTTP(w, r)
+		return
+	}
+	scheme := c.scheme
+	if scheme == "" {
+		scheme = requestScheme(r)
+	}
+	target := url.URL{
+		Scheme:   scheme,
+		Host:     c.host,
+		Path:     r.URL.Path,
+		RawPath:  r.URL.RawPath,
+		RawQuery: r.URL.RawQuery,
+	}
+	http.Redirect(w, r, target.String(), http.StatusMovedPermanently)
+}
+
+func (c *CanonicalHost) matches(r *http.Request) bool {
+	if c.scheme != "" && c.scheme != requestScheme(r) {
+		return false
+	}
+	host := r.Host
+	if !strings.Contains(c.host, ":") {
+		// The canonical host has no port, so ignore the one the
+		// request came in on.
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+	}
+	return strings.EqualFold(host, c.host)
+}
+
+func requestScheme(r *http.Request) string {
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		return strings.ToLower(proto)
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// NewCanonicalHost wraps next so that requests are redirected to canonical,
+// which is either a bare host ("example.com") or a scheme and host
+// ("https://example.com").
+func NewCanonicalHost(canonical string, next http.Handler) (*CanonicalHost, error) {
+	c := &CanonicalHost{host: canonical, next: next}
+	if strings.Contains(canonical, "://") {
+		u, err := url.Parse(canonical)
+		if err != nil {
+			return nil, err
+		}
+		c.scheme = u.Scheme
+		c.host = u.Host
+	}
+	return c, nil
+}
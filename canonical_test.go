@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCanonicalHost(t *testing.T) {
+	tests := []struct {
+		name         string
+		canonical    string
+		host         string
+		path         string
+		forwarded    string
+		wantStatus   int
+		wantLocation string
+	}{
+		{
+			name:       "canonical host passes through",
+			canonical:  "example.com",
+			host:       "example.com",
+			path:       "/abc",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "port is ignored when the canonical host has none",
+			canonical:  "example.com",
+			host:       "example.com:8080",
+			path:       "/abc",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "host comparison ignores case",
+			canonical:  "example.com",
+			host:       "EXAMPLE.com",
+			path:       "/abc",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:         "other host redirects keeping path and query",
+			canonical:    "example.com",
+			host:         "www.example.com",
+			path:         "/abc?x=1",
+			wantStatus:   http.StatusMovedPermanently,
+			wantLocation: "http://example.com/abc?x=1",
+		},
+		{
+			name:         "canonical scheme enforced",
+			canonical:    "https://example.com",
+			host:         "example.com",
+			path:         "/abc",
+			wantStatus:   http.StatusMovedPermanently,
+			wantLocation: "https://example.com/abc",
+		},
+		{
+			name:       "forwarded scheme counts",
+			canonical:  "https://example.com",
+			host:       "example.com",
+			path:       "/abc",
+			forwarded:  "https",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "health check exempt",
+			canonical:  "example.com",
+			host:       "10.0.0.5:8080",
+			path:       "/healthz",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "metrics exempt",
+			canonical:  "example.com",
+			host:       "10.0.0.5:8080",
+			path:       "/admin/metrics.json",
+			wantStatus: http.StatusOK,
+		},
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewCanonicalHost(tt.canonical, next)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest("GET", tt.path, nil)
+			req.Host = tt.host
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-Proto", tt.forwarded)
+			}
+			rec := httptest.NewRecorder()
+			c.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %v, want %v", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+type clickRecord struct {
+	Code         string    `json:"code"`
+	Time         time.Time `json:"time"`
+	Visitor      string    `json:"visitor"`
+	ReferrerHost string    `json:"referrer_host,omitempty"`
+	Country      string    `json:"country,omitempty"`
+}
+
+// ClickStreamHook appends one JSON record per redirect to a dedicated
+// file, separate from the request and audit logs. The file is rotated when
+// it exceeds maxBytes or the day changes. Visitors are identified by a
+// salted hash of their address and user agent; raw addresses are never
+// written.
+type ClickStreamHook struct {
+	filename string
+	maxBytes int64
+	salt     []byte
+	records  chan clickRecord
+	done     chan struct{}
+
+	// mu guards closed so no record is sent after records is closed.
+	mu     sync.RWMutex
+	closed bool
+
+	// The fields below are owned by the run goroutine.
+	file   *os.File
+	w      *bufio.Writer
+	size   int64
+	opened time.Time
+}
+
+func (h *ClickStreamHook) OnAdd(e Event)    {}
+func (h *ClickStreamHook) OnRemove(e Event) {}
+func (h *ClickStreamHook) OnUpdate(e Event) {}
+
+func (h *ClickStreamHook) OnRedirect(e Event) {
+	rec := clickRecord{
+		Code:    e.Code,
+		Time:    e.Time.UTC(),
+		Visitor: h.visitor(e),
+	}
+	if u, err := url.Parse(e.Referer); err == nil {
+		rec.ReferrerHost = u.Hostname()
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.closed {
+		return
+	}
+	select {
+	case h.records <- rec:
+	default:
+		log.Printf("click stream: queue full, dropping click on %v", e.Code)
+	}
+}
+
+func (h *ClickStreamHook) visitor(e Event) string {
+	ip := e.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	sum := sha256.New()
+	sum.Write(h.salt)
+	sum.Write([]byte(ip))
+	sum.Write([]byte{0})
+	sum.Write([]byte(e.UserAgent))
+	return hex.EncodeToString(sum.Sum(nil))[:16]
+}
+
+func (h *ClickStreamHook) run() {
+	defer close(h.done)
+	flush := time.NewTicker(time.Second)
+	defer flush.Stop()
+	for {
+		select {
+		case rec, ok := <-h.records:
+			if !ok {
+				h.w.Flush()
+				h.file.Close()
+				return
+			}
+			h.write(rec)
+		case <-flush.C:
+			h.w.Flush()
+		}
+	}
+}
+
+func (h *ClickStreamHook) write(rec clickRecord) {
+	now := time.Now()
+	if h.size >= h.maxBytes || now.YearDay() != h.opened.YearDay() || now.Year() != h.opened.Year() {
+		err := h.rotate()
+		if err != nil {
+			log.Printf("click stream: unable to rotate %v: %v", h.filename, err)
+		}
+	}
+	raw, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	n, err := h.w.Write(append(raw, '\n'))
+	h.size += int64(n)
+	if err != nil {
+		log.Printf("click stream: unable to write %v: %v", h.filename, err)
+	}
+}
+
+// rotate moves the current file aside with a timestamp suffix and starts a
+// new one.
+func (h *ClickStreamHook) rotate() error {
+	h.w.Flush()
+	h.file.Close()
+	rotated := fmt.Sprintf("%v.%v", h.filename, time.Now().UTC().Format("20060102T150405.000000000"))
+	renameErr := os.Rename(h.filename, rotated)
+	// Reopen even if the rename failed so clicks keep being recorded.
+	err := h.open()
+	if renameErr != nil {
+		return renameErr
+	}
+	return err
+}
+
+func (h *ClickStreamHook) open() error {
+	f, err := os.OpenFile(h.filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	h.file = f
+	h.w = bufio.NewWriter(f)
+	h.size = info.Size()
+	h.opened = info.ModTime()
+	if h.size == 0 {
+		h.opened = time.Now()
+	}
+	return nil
+}
+
+// Close flushes queued records and closes the file. Clicks arriving after
+// Close are dropped.
+func (h *ClickStreamHook) Close() {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return
+	}
+	h.closed = true
+	close(h.records)
+	h.mu.Unlock()
+	<-h.done
+}
+
+// NewClickStreamHook appends click records to filename, rotating it once it
+// grows past maxBytes.
+func NewClickStreamHook(filename string, maxBytes int64) (*ClickStreamHook, error) {
+	salt := make([]byte, 16)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	h := &ClickStreamHook{
+		filename: filename,
+		maxBytes: maxBytes,
+		salt:     salt,
+		records:  make(chan clickRecord, 4096),
+		done:     make(chan struct{}),
+	}
+	err = h.open()
+	if err != nil {
+		return nil, err
+	}
+	go h.run()
+	return h, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readClicks returns every record in the click-stream files matching
+// pattern.
+func readClicks(t *testing.T, pattern string) []clickRecord {
+	t.Helper()
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recs []clickRecord
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			var rec clickRecord
+			if err := json.Unmarshal(s.Bytes(), &rec); err != nil {
+				t.Fatalf("%v: %v", name, err)
+			}
+			recs = append(recs, rec)
+		}
+		f.Close()
+	}
+	return recs
+}
+
+func TestClickStreamHook(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "clicks.log")
+	h, err := NewClickStreamHook(filename, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := Event{
+		Code:       "abc",
+		Time:       time.Now(),
+		RemoteAddr: "192.0.2.1:5555",
+		UserAgent:  "test-agent",
+		Referer:    "https://news.example.org/story?id=1",
+	}
+	h.OnAdd(e)
+	h.OnRemove(e)
+	h.OnUpdate(e)
+	h.OnRedirect(e)
+	// A different port is the same visitor.
+	e.RemoteAddr = "192.0.2.1:6666"
+	e.Referer = ""
+	h.OnRedirect(e)
+	e.UserAgent = "other-agent"
+	h.OnRedirect(e)
+	h.Close()
+	// Clicks after Close are dropped rather than panicking.
+	h.OnRedirect(e)
+
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), "192.0.2.1") {
+		t.Errorf("raw address written to the click stream:\n%s", raw)
+	}
+	recs := readClicks(t, filename)
+	if len(recs) != 3 {
+		t.Fatalf("got %v records, want 3 (only redirects):\n%s", len(recs), raw)
+	}
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{name: "code", ok: recs[0].Code == "abc"},
+		{name: "time", ok: recs[0].Time.Equal(e.Time.UTC())},
+		{name: "referrer host", ok: recs[0].ReferrerHost == "news.example.org"},
+		{name: "no referrer", ok: recs[1].ReferrerHost == ""},
+		{name: "same visitor", ok: recs[0].Visitor != "" && recs[0].Visitor == recs[1].Visitor},
+		{name: "other visitor", ok: recs[1].Visitor != recs[2].Visitor},
+	}
+	for _, tt := range tests {
+		if !tt.ok {
+			t.Errorf("%v: unexpected records %+v", tt.name, recs)
+		}
+	}
+}
+
+func TestClickStreamHookRotation(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxBytes    int64
+		clicks      int
+		wantRotated bool
+	}{
+		{name: "below threshold", maxBytes: 1 << 20, clicks: 10},
+		{name: "past threshold", maxBytes: 300, clicks: 10, wantRotated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "clicks.log")
+			h, err := NewClickStreamHook(filename, tt.maxBytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < tt.clicks; i++ {
+				h.OnRedirect(Event{Code: "abc", Time: time.Now(), RemoteAddr: "192.0.2.1"})
+			}
+			h.Close()
+
+			rotated, _ := filepath.Glob(filename + ".*")
+			if (len(rotated) > 0) != tt.wantRotated {
+				t.Errorf("rotated files = %v, want rotation %v", rotated, tt.wantRotated)
+			}
+			for _, name := range rotated {
+				info, err := os.Stat(name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// A file is rotated on the first write past the limit, so
+				// it overshoots by at most one record.
+				if info.Size() > 2*tt.maxBytes {
+					t.Errorf("%v is %v bytes, limit %v", name, info.Size(), tt.maxBytes)
+				}
+			}
+			if recs := readClicks(t, filename+"*"); len(recs) != tt.clicks {
+				t.Errorf("got %v records across files, want %v", len(recs), tt.clicks)
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"math/rand"
+	"strings"
+	"sync"
+)
+
+// CodeGenerator produces the short code a destination is stored under.
+type CodeGenerator interface {
+	Generate(longURL string) (string, error)
+}
+
+// LengthGenerator is implemented by generators that can produce a code of
+// a caller-chosen length.
+type LengthGenerator interface {
+	GenerateLength(longURL string, length int) (string, error)
+}
+
+// DeterministicGenerator is implemented by generators that always return
+// the same code for the same destination, so clients can compute codes on
+// their own.
+type DeterministicGenerator interface {
+	CodeGenerator
+	Deterministic()
+}
+
+// Default code lengths when -code-length is not set. SHA-1 hex codes keep
+// the 10 characters they have always had.
+const (
+	defaultSHA1Length   = 10
+	defaultBase62Length = 7
+)
+
+// SHA1Generator derives the code from the SHA-1 of the destination, so the
+// same URL always yields the same code.
+type SHA1Generator struct {
+	length int
+}
+
+func (g SHA1Generator) Generate(longURL string) (string, error) {
+	return g.GenerateLength(longURL, g.length)
+}
+
+func (SHA1Generator) Deterministic() {}
+
+func (SHA1Generator) GenerateLength(longURL string, length int) (string, error) {
+	h := sha1.New()
+	h.Write([]byte(longURL))
+	sum := hex.EncodeToString(h.Sum(nil))
+	if length < 1 || length > len(sum) {
+		return "", fmt.Errorf("code length must be between 1 and %v", len(sum))
+	}
+	return sum[:length], nil
+}
+
+// base62Alphabet is the default alphabet of Base62Generator.
+const base62Alphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// Base62Generator encodes the SHA-1 of the destination in alphabet, so the
+// same URL always yields the same code. Codes are taken from the least
+// significant digits, so a longer code for the same URL extends a shorter
+// one.
+type Base62Generator struct {
+	alphabet string
+	length   int
+}
+
+func (g Base62Generator) Generate(longURL string) (string, error) {
+	return g.GenerateLength(longURL, g.length)
+}
+
+func (Base62Generator) Deterministic() {}
+
+func (g Base62Generator) GenerateLength(longURL string, length int) (string, error) {
+	base := big.NewInt(int64(len(g.alphabet)))
+	// Enough digits to represent any 160-bit digest.
+	maxLength := int(math.Ceil(160 / math.Log2(float64(len(g.alphabet)))))
+	if length < 1 || length > maxLength {
+		return "", fmt.Errorf("code length must be between 1 and %v", maxLength)
+	}
+	sum := sha1.Sum([]byte(longURL))
+	n := new(big.Int).SetBytes(sum[:])
+	digit := new(big.Int)
+	code := make([]byte, length)
+	for i := range code {
+		n.DivMod(n, base, digit)
+		code[i] = g.alphabet[digit.Int64()]
+	}
+	return string(code), nil
+}
+
+// mixedCase reports whether alphabet holds both cases of some letter, so
+// case folding would merge distinct codes.
+func mixedCase(alphabet string) bool {
+	for _, c := range alphabet {
+		if c >= 'A' && c <= 'Z' && strings.ContainsRune(alphabet, c-'A'+'a') {
+			return true
+		}
+	}
+	return false
+}
+
+func NewBase62Generator(alphabet string, length int) (Base62Generator, error) {
+	if len(alphabet) < 2 {
+		return Base62Generator{}, fmt.Errorf("code alphabet needs at least 2 characters")
+	}
+	seen := make(map[rune]bool)
+	for _, c := range alphabet {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '-' || c == '_') {
+			return Base62Generator{}, fmt.Errorf("code alphabet may only contain letters, digits, '-' and '_'")
+		}
+		if seen[c] {
+			return Base62Generator{}, fmt.Errorf("code alphabet repeats %q", c)
+		}
+		seen[c] = true
+	}
+	return Base62Generator{alphabet: alphabet, length: length}, nil
+}
+
+const (
+	consonants = "bdfghklmnprstvz"
+	vowels     = "aeiou"
+)
+
+// PronounceableGenerator builds codes out of syllables such as "tobo-fika".
+// pattern describes one syllable as a sequence of C (consonant) and V
+// (vowel) letters; syllables are grouped in pairs separated by dashes.
+type PronounceableGenerator struct {
+	store       Store
+	pattern     string
+	maxAttempts int
+	// growth, when set, adds a syllable once collisions become frequent.
+	growth *CollisionTracker
+
+	mu        sync.Mutex
+	syllables int
+}
+
+func (g *PronounceableGenerator) Generate(longURL string) (string, error) {
+	for i := 0; i < g.maxAttempts; i++ {
+		code := g.candidate()
+		_, err := g.store.Get(code)
+		collided := err == nil
+		if g.growth != nil && g.growth.Record(collided) {
+			g.grow()
+		}
+		if !collided {
+			return code, nil
+		}
+	}
+	return "", fmt.Errorf("unable to find a free code after %v attempts", g.maxAttempts)
+}
+
+func (g *PronounceableGenerator) grow() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.syllables++
+	log.Printf("pronounceable generator: collision rate over threshold, growing codes to %v syllables", g.syllables)
+}
+
+func (g *PronounceableGenerator) candidate() string {
+	g.mu.Lock()
+	syllables := g.syllables
+	g.mu.Unlock()
+
+	var b strings.Builder
+	for i := 0; i < syllables; i++ {
+		if i > 0 && i%2 == 0 {
+			b.WriteByte('-')
+		}
+		for _, c := range g.pattern {
+			if c == 'C' {
+				b.WriteByte(consonants[rand.Intn(len(consonants))])
+			} else {
+				b.WriteByte(vowels[rand.Intn(len(vowels))])
+			}
+		}
+	}
+	return b.String()
+}
+
+func NewPronounceableGenerator(store Store, pattern string, syllables int) (*PronounceableGenerator, error) {
+	pattern = strings.ToUpper(pattern)
+	if pattern == "" || strings.Trim(pattern, "CV") != "" {
+		return nil, fmt.Errorf("syllable pattern %q must consist of C and V", pattern)
+	}
+	if syllables < 1 {
+		return nil, fmt.Errorf("syllable count must be at least 1")
+	}
+	return &PronounceableGenerator{
+		store:       store,
+		pattern:     pattern,
+		syllables:   syllables,
+		maxAttempts: 10,
+	}, nil
+}
+
+// CollisionTracker watches the share of generated codes that were already
+// taken over a sliding window of recent attempts.
+type CollisionTracker struct {
+	mu        sync.Mutex
+	window    []bool
+	next      int
+	filled    int
+	collided  int
+	threshold float64
+}
+
+// Record notes whether an attempt collided and reports whether the
+// collision rate over a full window has exceeded the threshold. The window
+// is cleared when it has, so the effect of growing is measured afresh.
+func (t *CollisionTracker) Record(collided bool) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.filled == len(t.window) {
+		if t.window[t.next] {
+			t.collided--
+		}
+	} else {
+		t.filled++
+	}
+	t.window[t.next] = collided
+	if collided {
+		t.collided++
+	}
+	t.next = (t.next + 1) % len(t.window)
+
+	if t.filled < len(t.window) || float64(t.collided)/float64(t.filled) <= t.threshold {
+		return false
+	}
+	for i := range t.window {
+		t.window[i] = false
+	}
+	t.next, t.filled, t.collided = 0, 0, 0
+	return true
+}
+
+// NewCollisionTracker signals growth once more than threshold (0-1) of the
+// last size attempts collided.
+func NewCollisionTracker(threshold float64, size int) *CollisionTracker {
+	return &CollisionTracker{window: make([]bool, size), threshold: threshold}
+}
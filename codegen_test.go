@@ -0,0 +1,258 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestPronounceableGenerator(t *testing.T) {
+	tests := []struct {
+		pattern   string
+		syllables int
+		want      string
+	}{
+		{pattern: "CV", syllables: 1, want: `^[bdfghklmnprstvz][aeiou]$`},
+		{pattern: "CV", syllables: 4, want: `^([bdfghklmnprstvz][aeiou]){2}-([bdfghklmnprstvz][aeiou]){2}$`},
+		{pattern: "cvc", syllables: 3, want: `^([bdfghklmnprstvz][aeiou][bdfghklmnprstvz]){2}-[bdfghklmnprstvz][aeiou][bdfghklmnprstvz]$`},
+		{pattern: "V", syllables: 2, want: `^[aeiou]{2}$`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			g, err := NewPronounceableGenerator(NewMemoryStore(), tt.pattern, tt.syllables)
+			if err != nil {
+				t.Fatal(err)
+			}
+			re := regexp.MustCompile(tt.want)
+			for i := 0; i < 50; i++ {
+				code, err := g.Generate("https://example.com/")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !re.MatchString(code) {
+					t.Fatalf("code %q does not match %v", code, tt.want)
+				}
+				if !validAlias(code) {
+					t.Fatalf("code %q is not a valid alias", code)
+				}
+			}
+		})
+	}
+}
+
+func TestPronounceableGeneratorInvalid(t *testing.T) {
+	tests := []struct {
+		name      string
+		pattern   string
+		syllables int
+	}{
+		{name: "empty pattern", pattern: "", syllables: 2},
+		{name: "other letters", pattern: "CVX", syllables: 2},
+		{name: "no syllables", pattern: "CV", syllables: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPronounceableGenerator(NewMemoryStore(), tt.pattern, tt.syllables)
+			if err == nil {
+				t.Errorf("got no error")
+			}
+		})
+	}
+}
+
+func TestPronounceableGeneratorAvoidsTakenCodes(t *testing.T) {
+	// One CV syllable gives 75 codes, so collisions are frequent.
+	store := NewMemoryStore()
+	g, err := NewPronounceableGenerator(store, "CV", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		code, err := g.Generate("https://example.com/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = store.Add(code, Link{URL: "https://example.com/"})
+		if err != nil {
+			t.Fatalf("generated taken code %q: %v", code, err)
+		}
+	}
+}
+
+func TestCollisionTracker(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold float64
+		attempts  string // x collided, . did not
+		want      string // g where Record reported growth
+	}{
+		{name: "window not yet full", threshold: 0.5, attempts: "xxx", want: "..."},
+		{name: "at threshold", threshold: 0.5, attempts: "x.x.x.", want: "......"},
+		{name: "over threshold", threshold: 0.5, attempts: "xx.x", want: "...g"},
+		{name: "sliding window", threshold: 0.5, attempts: "....xxx", want: "......g"},
+		{name: "cleared after growth", threshold: 0.5, attempts: "xxxxxxxx", want: "...g...g"},
+		{name: "never collides", threshold: 0, attempts: "........", want: "........"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := NewCollisionTracker(tt.threshold, 4)
+			var got strings.Builder
+			for _, c := range tt.attempts {
+				if tr.Record(c == 'x') {
+					got.WriteByte('g')
+				} else {
+					got.WriteByte('.')
+				}
+			}
+			if got.String() != tt.want {
+				t.Errorf("got %v, want %v", got.String(), tt.want)
+			}
+		})
+	}
+}
+
+// shortCodesTaken reports every code of fewer than minLen characters as
+// taken, like a store whose short keyspace is full.
+type shortCodesTaken struct {
+	*MemoryStore
+	minLen int
+}
+
+func (s shortCodesTaken) Get(code string) (Link, error) {
+	if len(code) < s.minLen {
+		return Link{URL: "https://example.com/"}, nil
+	}
+	return s.MemoryStore.Get(code)
+}
+
+func TestPronounceableGeneratorGrows(t *testing.T) {
+	tests := []struct {
+		name          string
+		growth        bool
+		wantSyllables int
+		wantErr       bool
+	}{
+		{name: "grows under collision pressure", growth: true, wantSyllables: 3},
+		{name: "fixed length exhausts attempts", wantSyllables: 2, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Two CV syllables are "baba"; every code until the third
+			// syllable ("baba-ba") is taken.
+			store := shortCodesTaken{MemoryStore: NewMemoryStore(), minLen: len("baba-ba")}
+			g, err := NewPronounceableGenerator(store, "CV", 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.growth {
+				g.growth = NewCollisionTracker(0.5, 5)
+			}
+			code, err := g.Generate("https://example.com/")
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if g.syllables != tt.wantSyllables {
+				t.Errorf("syllables = %v, want %v", g.syllables, tt.wantSyllables)
+			}
+			if err == nil && len(code) != len("baba-ba") {
+				t.Errorf("code %q does not have three syllables", code)
+			}
+		})
+	}
+}
+
+func TestBase62Generator(t *testing.T) {
+	tests := []struct {
+		name     string
+		alphabet string
+		length   int
+	}{
+		{name: "default", alphabet: base62Alphabet, length: defaultBase62Length},
+		{name: "short", alphabet: base62Alphabet, length: 4},
+		{name: "long", alphabet: base62Alphabet, length: 20},
+		{name: "lowercase", alphabet: "0123456789abcdefghijklmnopqrstuvwxyz", length: 8},
+		{name: "binary", alphabet: "01", length: 16},
+		{name: "url-safe extras", alphabet: base62Alphabet + "-_", length: 6},
+	}
+	urls := []string{"https://example.com/", "https://example.com/a", "https://example.org/?q=1", ""}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := NewBase62Generator(tt.alphabet, tt.length)
+			if err != nil {
+				t.Fatal(err)
+			}
+			seen := make(map[string]bool)
+			for _, u := range urls {
+				code, err := g.Generate(u)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(code) != tt.length {
+					t.Errorf("%q: code %q has length %v, want %v", u, code, len(code), tt.length)
+				}
+				if strings.Trim(code, tt.alphabet) != "" {
+					t.Errorf("%q: code %q uses characters outside %q", u, code, tt.alphabet)
+				}
+				again, _ := g.Generate(u)
+				if again != code {
+					t.Errorf("%q: got %q then %q", u, code, again)
+				}
+				longer, err := g.GenerateLength(u, tt.length+1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.HasPrefix(longer, code) {
+					t.Errorf("%q: longer code %q does not extend %q", u, longer, code)
+				}
+				seen[code] = true
+			}
+			if len(seen) != len(urls) {
+				t.Errorf("distinct URLs shared codes: %v", seen)
+			}
+		})
+	}
+}
+
+func TestBase62GeneratorInvalid(t *testing.T) {
+	for _, alphabet := range []string{"", "a", "abca", "abc+", "abc/", "abcé"} {
+		if _, err := NewBase62Generator(alphabet, 7); err == nil {
+			t.Errorf("%q: expected an error", alphabet)
+		}
+	}
+	g, _ := NewBase62Generator(base62Alphabet, 7)
+	// 27 base62 digits cover a 160-bit digest.
+	for _, length := range []int{0, -1, 28} {
+		if _, err := g.GenerateLength("https://example.com/", length); err == nil {
+			t.Errorf("length %v: expected an error", length)
+		}
+	}
+	if _, err := g.GenerateLength("https://example.com/", 27); err != nil {
+		t.Errorf("length 27: %v", err)
+	}
+}
+
+func TestSHA1GeneratorDefaultLength(t *testing.T) {
+	code, err := SHA1Generator{length: defaultSHA1Length}.Generate("https://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code) != 10 || strings.Trim(code, "0123456789abcdef") != "" {
+		t.Errorf("code %q is not 10 hex characters", code)
+	}
+}
+
+func TestMixedCase(t *testing.T) {
+	tests := map[string]bool{
+		base62Alphabet:                         true,
+		"0123456789abcdefghijklmnopqrstuvwxyz": false,
+		"0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ": false,
+		"abcXYZ":                               false,
+		"abcC":                                 true,
+		"01-_":                                 false,
+	}
+	for alphabet, want := range tests {
+		if got := mixedCase(alphabet); got != want {
+			t.Errorf("mixedCase(%q) = %v, want %v", alphabet, got, want)
+		}
+	}
+}
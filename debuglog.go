@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+)
+
+// sensitiveField matches JSON string fields whose values must never be
+// logged.
+var sensitiveField = regexp.MustCompile(`(?i)("[^"]*(?:password|secret|token)[^"]*"\s*:\s*)"(?:[^"\\]|\\.)*"`)
+
+// BodyLogger logs the request body of requests that end in an error
+// response, to help reproduce failures. Bodies are capped at limit bytes
+// and sensitive fields are redacted. Successful requests are never logged.
+type BodyLogger struct {
+	next  http.Handler
+	limit int
+}
+
+func (b *BodyLogger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	captured := &cappedBuffer{limit: b.limit}
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(r.Body, captured), r.Body}
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+	b.next.ServeHTTP(rec, r)
+
+	if rec.status < 400 {
+		return
+	}
+	body := sensitiveField.ReplaceAll(captured.Bytes(), []byte(`$1"[REDACTED]"`))
+	suffix := ""
+	if captured.truncated {
+		suffix = " (truncated)"
+	}
+	log.Printf("%v %v failed with %v, request body%v: %s", r.Method, r.URL.Path, rec.status, suffix, body)
+}
+
+// cappedBuffer keeps the first limit bytes written to it and discards the
+// rest.
+type cappedBuffer struct {
+	bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+	room := c.limit - c.Len()
+	if room < len(p) {
+		c.truncated = true
+		if room > 0 {
+			c.Buffer.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return c.Buffer.Write(p)
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBodyLogger(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		limit   int
+		body    string
+		want    []string
+		wantNot []string
+	}{
+		{
+			name:    "failed request logged",
+			enabled: true,
+			limit:   1024,
+			body:    `{"url":"ftp://example.com/"}`,
+			want:    []string{"failed with 400", `{"url":"ftp://example.com/"}`},
+		},
+		{
+			name:    "successful request not logged",
+			enabled: true,
+			limit:   1024,
+			body:    `{"url":"https://example.com/"}`,
+			wantNot: []string{"example.com"},
+		},
+		{
+			name:    "disabled by default",
+			body:    `{"url":"ftp://example.com/"}`,
+			wantNot: []string{"example.com"},
+		},
+		{
+			name:    "sensitive fields redacted",
+			enabled: true,
+			limit:   1024,
+			body:    `{"url":"ftp://example.com/","password":"hunter2","api_token":"t0k"}`,
+			want:    []string{`"password":"[REDACTED]"`, `"api_token":"[REDACTED]"`},
+			wantNot: []string{"hunter2", "t0k"},
+		},
+		{
+			name:    "body capped",
+			enabled: true,
+			limit:   10,
+			body:    `{"url":"ftp://example.com/"}`,
+			want:    []string{"(truncated)", `{"url":"ft`},
+			wantNot: []string{"example.com"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logged bytes.Buffer
+			log.SetOutput(&logged)
+			defer log.SetOutput(os.Stderr)
+
+			a := newTestAddPath(NewMemoryStore())
+			a.httpsOnly = true
+			var h http.Handler = a
+			if tt.enabled {
+				h = &BodyLogger{next: a, limit: tt.limit}
+			}
+			do(h, "POST", "/add", tt.body)
+
+			out := logged.String()
+			for _, s := range tt.want {
+				if !strings.Contains(out, s) {
+					t.Errorf("log does not contain %q: %q", s, out)
+				}
+			}
+			for _, s := range tt.wantNot {
+				if strings.Contains(out, s) {
+					t.Errorf("log contains %q: %q", s, out)
+				}
+			}
+		})
+	}
+}
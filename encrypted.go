@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/base64"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// encryptedPrefix marks a stored destination as client-encrypted
+// ciphertext rather than a URL.
+const encryptedPrefix = "enc:"
+
+// minCiphertextLen is the AES-GCM nonce plus tag; anything shorter cannot
+// hold an encrypted URL.
+const minCiphertextLen = 12 + 16
+
+func isEncrypted(longURL string) bool {
+	return strings.HasPrefix(longURL, encryptedPrefix)
+}
+
+// validCiphertext reports whether s is unpadded base64url long enough to
+// hold a nonce, tag and some payload.
+func validCiphertext(s string) bool {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	return err == nil && len(raw) > minCiphertextLen
+}
+
+var decryptTemplate = template.Must(template.New("decrypt").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="referrer" content="no-referrer">
+<title>Redirecting</title>
+</head>
+<body>
+<noscript>This link is encrypted and needs JavaScript to open.</noscript>
+<p id="status">Redirecting…</p>
+<script>
+(async function() {
+	function decode(s) {
+		s = s.replace(/-/g, "+").replace(/_/g, "/");
+		var bin = atob(s + "===".slice((s.length + 3) % 4));
+		return Uint8Array.from(bin, function(c) { return c.charCodeAt(0); });
+	}
+	try {
+		var raw = decode({{.Ciphertext}});
+		var key = await crypto.subtle.importKey("raw", decode(location.hash.slice(1)), "AES-GCM", false, ["decrypt"]);
+		var plain = await crypto.subtle.decrypt({name: "AES-GCM", iv: raw.slice(0, 12)}, key, raw.slice(12));
+		var dest = new URL(new TextDecoder().decode(plain));
+		if (dest.protocol !== "https:" && dest.protocol !== "http:") {
+			throw new Error("unsupported scheme");
+		}
+		location.replace(dest.href);
+	} catch (e) {
+		document.getElementById("status").textContent = "Unable to open this link: the key is missing or wrong.";
+	}
+})();
+</script>
+</body>
+</html>
+`))
+
+// writeDecryptPage serves a page that decrypts ciphertext with the key in
+// the URL fragment and navigates to the result. The server never sees the
+// key or the destination.
+func writeDecryptPage(w http.ResponseWriter, ciphertext string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.WriteHeader(http.StatusOK)
+	decryptTemplate.Execute(w, struct {
+		Ciphertext string
+	}{Ciphertext: ciphertext})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// encryptURL does what a client does before POST /add: AES-GCM with a
+// random nonce prepended, as unpadded base64url.
+func encryptURL(t *testing.T, key []byte, longURL string) string {
+	t.Helper()
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	rand.Read(nonce)
+	return base64.RawURLEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(longURL), nil))
+}
+
+func decryptURL(t *testing.T, key []byte, ciphertext string) string {
+	t.Helper()
+	raw, err := base64.RawURLEncoding.DecodeString(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := aes.NewCipher(key)
+	gcm, _ := cipher.NewGCM(block)
+	plain, err := gcm.Open(nil, raw[:gcm.NonceSize()], raw[gcm.NonceSize():], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(plain)
+}
+
+var pageCiphertext = regexp.MustCompile(`decode\("([A-Za-z0-9_-]+)"\)`)
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	const longURL = "https://example.com/secret?q=1"
+	ciphertext := encryptURL(t, key, longURL)
+
+	store := NewMemoryStore()
+	a := newTestAddPath(store)
+	a.allowEncrypted = true
+	body, _ := json.Marshal(map[string]string{"ciphertext": ciphertext, "alias": "enc"})
+	rec := do(a, "POST", "/add", string(body))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("add status = %v: %s", rec.Code, rec.Body)
+	}
+	if strings.Contains(rec.Body.String(), "example.com") {
+		t.Errorf("response leaks the destination: %s", rec.Body)
+	}
+
+	rec = doHash(&RedirectPath{store: store, redirectStatus: http.StatusFound}, "GET", "enc", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("redirect status = %v, want the decrypt page", rec.Code)
+	}
+	if rec.Header().Get("Location") != "" {
+		t.Errorf("server redirected to %v", rec.Header().Get("Location"))
+	}
+	m := pageCiphertext.FindStringSubmatch(rec.Body.String())
+	if m == nil {
+		t.Fatalf("page does not embed the ciphertext:\n%s", rec.Body)
+	}
+	if got := decryptURL(t, key, m[1]); got != longURL {
+		t.Errorf("decrypted %q, want %q", got, longURL)
+	}
+}
+
+func TestEncryptedAddRejected(t *testing.T) {
+	valid := encryptURL(t, make([]byte, 16), "https://example.com/")
+	tests := []struct {
+		name    string
+		enabled bool
+		body    map[string]string
+	}{
+		{name: "disabled", body: map[string]string{"ciphertext": valid}},
+		{name: "with url", enabled: true, body: map[string]string{"ciphertext": valid, "url": "https://example.com/"}},
+		{name: "too short", enabled: true, body: map[string]string{"ciphertext": "AAAA"}},
+		{name: "padded", enabled: true, body: map[string]string{"ciphertext": valid + "=="}},
+		{name: "not base64url", enabled: true, body: map[string]string{"ciphertext": strings.Repeat("+", 60)}},
+		{name: "prefixed url when disabled", body: map[string]string{"url": encryptedPrefix + valid}},
+		{name: "prefixed url when enabled", enabled: true, body: map[string]string{"url": encryptedPrefix + valid}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAddPath(NewMemoryStore())
+			a.allowEncrypted = tt.enabled
+			body, _ := json.Marshal(tt.body)
+			rec := do(a, "POST", "/add", string(body))
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %v, want %v", rec.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// Event describes a link operation passed to an EventHook.
+type Event struct {
+	Code       string
+	LongURL    string
+	Time       time.Time
+	RemoteAddr string
+	Referer    string
+	UserAgent  string
+	RequestID  string
+}
+
+func newEvent(r *http.Request, code, longURL string) Event {
+	return Event{
+		Code:       code,
+		LongURL:    longURL,
+		Time:       time.Now(),
+		RemoteAddr: r.RemoteAddr,
+		Referer:    r.Referer(),
+		UserAgent:  r.UserAgent(),
+		RequestID:  r.Header.Get("X-Request-ID"),
+	}
+}
+
+// EventHook receives link operations after they have succeeded. Hooks run
+// asynchronously and must be safe for concurrent use.
+type EventHook interface {
+	OnAdd(e Event)
+	OnRemove(e Event)
+	OnRedirect(e Event)
+	OnUpdate(e Event)
+}
+
+// Hooks fans events out to every registered EventHook without blocking the
+// caller.
+type Hooks []EventHook
+
+func (hs Hooks) Add(e Event) {
+	for _, h := range hs {
+		go h.OnAdd(e)
+	}
+}
+
+func (hs Hooks) Remove(e Event) {
+	for _, h := range hs {
+		go h.OnRemove(e)
+	}
+}
+
+func (hs Hooks) Redirect(e Event) {
+	for _, h := range hs {
+		go h.OnRedirect(e)
+	}
+}
+
+func (hs Hooks) Update(e Event) {
+	for _, h := range hs {
+		go h.OnUpdate(e)
+	}
+}
+
+// LogHook writes every event to the standard logger.
+type LogHook struct{}
+
+func (LogHook) OnAdd(e Event) {
+	log.Printf("event: add %v -> %v", e.Code, e.LongURL)
+}
+
+func (LogHook) OnRemove(e Event) {
+	log.Printf("event: remove %v", e.Code)
+}
+
+func (LogHook) OnRedirect(e Event) {
+	log.Printf("event: redirect %v -> %v from %v", e.Code, e.LongURL, e.RemoteAddr)
+}
+
+func (LogHook) OnUpdate(e Event) {
+	log.Printf("event: update %v -> %v", e.Code, e.LongURL)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type hookCall struct {
+	kind  string
+	event Event
+}
+
+// recordingHook sends every call it receives on calls.
+type recordingHook struct {
+	calls chan hookCall
+}
+
+func newRecordingHook() *recordingHook {
+	return &recordingHook{calls: make(chan hookCall, 10)}
+}
+
+func (h *recordingHook) OnAdd(e Event)      { h.calls <- hookCall{"add", e} }
+func (h *recordingHook) OnRemove(e Event)   { h.calls <- hookCall{"remove", e} }
+func (h *recordingHook) OnRedirect(e Event) { h.calls <- hookCall{"redirect", e} }
+func (h *recordingHook) OnUpdate(e Event)   { h.calls <- hookCall{"update", e} }
+
+// next waits up to wait for the hook's next call, returning false when
+// none arrives.
+func (h *recordingHook) next(wait time.Duration) (hookCall, bool) {
+	select {
+	case c := <-h.calls:
+		return c, true
+	case <-time.After(wait):
+		return hookCall{}, false
+	}
+}
+
+func TestHooks(t *testing.T) {
+	tests := []struct {
+		name string
+		// handler builds the handler under test around store and hooks.
+		handler     func(store Store, hooks Hooks) http.Handler
+		method      string
+		hash        string
+		body        string
+		wantKind    string
+		wantCode    string
+		wantLongURL string
+	}{
+		{
+			name: "add",
+			handler: func(store Store, hooks Hooks) http.Handler {
+				a := newTestAddPath(store)
+				a.hooks = hooks
+				return a
+			},
+			method:      "POST",
+			body:        `{"url":"https://example.com/new","alias":"new"}`,
+			wantKind:    "add",
+			wantCode:    "new",
+			wantLongURL: "https://example.com/new",
+		},
+		{
+			name: "failed add fires nothing",
+			handler: func(store Store, hooks Hooks) http.Handler {
+				a := newTestAddPath(store)
+				a.hooks = hooks
+				return a
+			},
+			method: "POST",
+			body:   `{"url":"https://example.com/new","alias":"abc"}`,
+		},
+		{
+			name: "remove",
+			handler: func(store Store, hooks Hooks) http.Handler {
+				return &DeletePath{store: store, hooks: hooks}
+			},
+			method:   "DELETE",
+			hash:     "abc",
+			wantKind: "remove",
+			wantCode: "abc",
+		},
+		{
+			name: "redirect",
+			handler: func(store Store, hooks Hooks) http.Handler {
+				return &RedirectPath{store: store, hooks: hooks, redirectStatus: http.StatusFound}
+			},
+			method:      "GET",
+			hash:        "abc",
+			wantKind:    "redirect",
+			wantCode:    "abc",
+			wantLongURL: "https://example.com/abc",
+		},
+		{
+			name: "update",
+			handler: func(store Store, hooks Hooks) http.Handler {
+				return &RewritePath{store: store, add: newTestAddPath(store), hooks: hooks}
+			},
+			method:      "POST",
+			body:        `{"from_host":"example.com","to_host":"example.org"}`,
+			wantKind:    "update",
+			wantCode:    "abc",
+			wantLongURL: "https://example.org/abc",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			mustAdd(t, store, "abc", "https://example.com/abc")
+			first, second := newRecordingHook(), newRecordingHook()
+			h := tt.handler(store, Hooks{first, second})
+
+			req := httptest.NewRequest(tt.method, "/"+tt.hash, strings.NewReader(tt.body))
+			req = mux.SetURLVars(req, map[string]string{"hash": tt.hash})
+			req.Header.Set("X-Request-ID", "req-1")
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			for _, hook := range []*recordingHook{first, second} {
+				if tt.wantKind == "" {
+					if c, ok := hook.next(100 * time.Millisecond); ok {
+						t.Errorf("unexpected %v event", c.kind)
+					}
+					continue
+				}
+				c, ok := hook.next(time.Second)
+				if !ok {
+					t.Fatalf("no %v event", tt.wantKind)
+				}
+				if c.kind != tt.wantKind || c.event.Code != tt.wantCode || c.event.LongURL != tt.wantLongURL {
+					t.Errorf("got %v %+v, want %v of %v -> %v", c.kind, c.event, tt.wantKind, tt.wantCode, tt.wantLongURL)
+				}
+				if c.event.RequestID != "req-1" {
+					t.Errorf("request ID = %q, want req-1", c.event.RequestID)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var interstitialTemplate = template.Must(template.New("interstitial").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+{{if .Navigable}}<meta http-equiv="refresh" content="{{.Seconds}};url={{.URL}}">{{end}}
+<title>Leaving to {{.Host}}</title>
+</head>
+<body>
+{{if .Navigable}}<p>You are leaving to <strong>{{.Host}}</strong> in <span id="countdown">{{.Seconds}}</span> seconds.</p>
+<p><a href="{{.URL}}">{{.URL}}</a></p>
+<script>
+var remaining = {{.Seconds}};
+var timer = setInterval(function() {
+	remaining--;
+	document.getElementById("countdown").textContent = remaining;
+	if (remaining <= 0) {
+		clearInterval(timer);
+		window.location.href = {{.URL}};
+	}
+}, 1000);
+</script>
+{{else}}<p>This link points to <strong>{{.URL}}</strong>, which cannot be opened from here.</p>
+{{end}}
+</body>
+</html>
+`))
+
+// writeInterstitial serves a page showing the destination of a short link
+// and redirecting to it once delay has passed. Only http and https
+// destinations are navigated to; anything else (javascript:, data:, ...)
+// would run on the shortener's own origin.
+func writeInterstitial(w http.ResponseWriter, longURL string, delay time.Duration) {
+	host := longURL
+	navigable := false
+	u, err := url.Parse(longURL)
+	if err == nil && u.Host != "" {
+		host = u.Host
+	}
+	if err == nil {
+		scheme := strings.ToLower(u.Scheme)
+		navigable = scheme == "http" || scheme == "https"
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	interstitialTemplate.Execute(w, struct {
+		URL       string
+		Host      string
+		Seconds   int
+		Navigable bool
+	}{
+		URL:       longURL,
+		Host:      host,
+		Seconds:   int(delay.Seconds()),
+		Navigable: navigable,
+	})
+}
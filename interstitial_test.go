@@ -0,0 +1,149 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestRedirectInterstitial(t *testing.T) {
+	tests := []struct {
+		name         string
+		longURL      string
+		interstitial bool
+		// perLink sets the link's own interstitial flag.
+		perLink    bool
+		accept     string
+		wantStatus int
+		wantBody   []string
+		wantNoBody []string
+	}{
+		{
+			name:       "direct redirect when disabled",
+			longURL:    "https://example.com/page",
+			wantStatus: http.StatusTemporaryRedirect,
+		},
+		{
+			name:         "interstitial page navigates to https",
+			longURL:      "https://example.com/page",
+			interstitial: true,
+			wantStatus:   http.StatusOK,
+			wantBody:     []string{"example.com", `http-equiv="refresh"`, "window.location.href"},
+		},
+		{
+			name:       "per-link interstitial with the global toggle off",
+			longURL:    "https://example.com/page",
+			perLink:    true,
+			wantStatus: http.StatusOK,
+			wantBody:   []string{"example.com", `http-equiv="refresh"`, "window.location.href"},
+		},
+		{
+			name:       "per-link interstitial JSON clients get the destination",
+			longURL:    "https://example.com/page",
+			perLink:    true,
+			accept:     "application/json",
+			wantStatus: http.StatusOK,
+			wantBody:   []string{`"long_url":"https://example.com/page"`},
+		},
+		{
+			name:         "JSON clients get the destination",
+			longURL:      "https://example.com/page",
+			interstitial: true,
+			accept:       "application/json",
+			wantStatus:   http.StatusOK,
+			wantBody:     []string{`"long_url":"https://example.com/page"`},
+		},
+		{
+			name:         "javascript destination is never navigated to",
+			longURL:      "javascript:alert(document.cookie)",
+			interstitial: true,
+			wantStatus:   http.StatusOK,
+			wantNoBody:   []string{"window.location", `http-equiv="refresh"`, "alert(document.cookie)\""},
+		},
+		{
+			name:         "data destination is never navigated to",
+			longURL:      "data:text/html,<script>alert(1)</script>",
+			interstitial: true,
+			wantStatus:   http.StatusOK,
+			wantNoBody:   []string{"window.location", `http-equiv="refresh"`, "<script>alert(1)"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			store.Add("abc", Link{URL: tt.longURL, Interstitial: tt.perLink})
+			p := &RedirectPath{
+				store:             store,
+				redirectStatus:    http.StatusTemporaryRedirect,
+				interstitial:      tt.interstitial,
+				interstitialDelay: 3 * time.Second,
+			}
+			req := mux.SetURLVars(httptest.NewRequest("GET", "/abc", nil), map[string]string{"hash": "abc"})
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			p.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %v, want %v", rec.Code, tt.wantStatus)
+			}
+			body := rec.Body.String()
+			for _, s := range tt.wantBody {
+				if !strings.Contains(body, s) {
+					t.Errorf("body does not contain %q:\n%s", s, body)
+				}
+			}
+			for _, s := range tt.wantNoBody {
+				if strings.Contains(body, s) {
+					t.Errorf("body contains %q:\n%s", s, body)
+				}
+			}
+		})
+	}
+}
+
+func TestAddPathInterstitial(t *testing.T) {
+	stores := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store { return NewMemoryStore() },
+		"file":   func(t *testing.T) Store { return newTestFileStore(t, 0) },
+		"sql":    func(t *testing.T) Store { return newTestSQLStore(t) },
+	}
+	tests := []struct {
+		name string
+		body string
+		want bool
+	}{
+		{name: "default", body: `{"url": "https://example.com/", "alias": "abc"}`},
+		{name: "requested", body: `{"url": "https://example.com/", "alias": "abc", "interstitial": true}`, want: true},
+	}
+	for storeName, newStore := range stores {
+		for _, tt := range tests {
+			t.Run(storeName+"/"+tt.name, func(t *testing.T) {
+				store := newStore(t)
+				rec := do(newTestAddPath(store), "POST", "/add", tt.body)
+				if rec.Code != http.StatusCreated {
+					t.Fatalf("status = %v: %s", rec.Code, rec.Body)
+				}
+				if got, _ := decode(t, rec)["interstitial"].(bool); got != tt.want {
+					t.Errorf("response interstitial = %v, want %v", got, tt.want)
+				}
+				link, err := store.Get("abc")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if link.Interstitial != tt.want {
+					t.Errorf("stored interstitial = %v, want %v", link.Interstitial, tt.want)
+				}
+				records, err := store.List(10, 0)
+				if err != nil || len(records) != 1 || records[0].Interstitial != tt.want {
+					t.Errorf("listed %+v, %v", records, err)
+				}
+			})
+		}
+	}
+}
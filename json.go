@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// camelCaseJSON switches the keys of every JSON response from the default
+// snake_case (shortened_url) to camelCase (shortenedUrl).
+var camelCaseJSON bool
+
+// writeJSON encodes v as the response body with the given status, applying
+// the configured key casing.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	raw, err := json.Marshal(v)
+	if err == nil && camelCaseJSON {
+		raw, err = camelizeKeys(raw)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("unable to encode response: %v", err)))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(raw)
+	w.Write([]byte("\n"))
+}
+
+// writeJSONError reports an error as {"error": message} with the given
+// status.
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{Error: message})
+}
+
+// camelizeKeys rewrites every object key in raw from snake_case to
+// camelCase.
+func camelizeKeys(raw []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v interface{}
+	err := dec.Decode(&v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(camelizeValue(v))
+}
+
+func camelizeValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			out[snakeToCamel(k)] = camelizeValue(val)
+		}
+		return out
+	case []interface{}:
+		for i, val := range v {
+			v[i] = camelizeValue(val)
+		}
+		return v
+	}
+	return v
+}
+
+func snakeToCamel(s string) string {
+	parts := strings.Split(s, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
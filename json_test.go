@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func keys(m map[string]interface{}) string {
+	var ks []string
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return strings.Join(ks, ",")
+}
+
+func TestJSONCase(t *testing.T) {
+	defer func() { camelCaseJSON = false }()
+	tests := []struct {
+		name      string
+		camel     bool
+		wantAdd   string
+		wantError string
+	}{
+		{name: "snake", wantAdd: "expires_at,long_url,shortened_url", wantError: "error"},
+		{name: "camel", camel: true, wantAdd: "expiresAt,longUrl,shortenedUrl", wantError: "error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			camelCaseJSON = tt.camel
+			a := newTestAddPath(NewMemoryStore())
+			rec := do(a, "POST", "/add", `{"url": "https://example.com/", "ttl_seconds": 60}`)
+			if rec.Code != http.StatusCreated {
+				t.Fatalf("status = %v: %s", rec.Code, rec.Body)
+			}
+			if got := keys(decode(t, rec)); got != tt.wantAdd {
+				t.Errorf("add keys = %v, want %v", got, tt.wantAdd)
+			}
+
+			rec = do(a, "POST", "/add", `{"url": "https://example.com/", "ttl_seconds": -1}`)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %v: %s", rec.Code, rec.Body)
+			}
+			if got := keys(decode(t, rec)); got != tt.wantError {
+				t.Errorf("error keys = %v, want %v", got, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestJSONCaseNested(t *testing.T) {
+	defer func() { camelCaseJSON = false }()
+	camelCaseJSON = true
+	type item struct {
+		LongURL  string `json:"long_url"`
+		HitCount int64  `json:"hit_count"`
+	}
+	rec := httptest.NewRecorder()
+	writeJSON(rec, http.StatusOK, map[string]interface{}{
+		"next_offset": 10,
+		"link_items":  []item{{LongURL: "https://example.com/", HitCount: 12345678901234}},
+	})
+	const want = `{"linkItems":[{"hitCount":12345678901234,"longUrl":"https://example.com/"}],"nextOffset":10}` + "\n"
+	if got := rec.Body.String(); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestSnakeToCamel(t *testing.T) {
+	tests := map[string]string{
+		"url":           "url",
+		"long_url":      "longUrl",
+		"ttl_seconds":   "ttlSeconds",
+		"p50_ms":        "p50Ms",
+		"trailing_":     "trailing",
+		"double__under": "doubleUnder",
+	}
+	for in, want := range tests {
+		if got := snakeToCamel(in); got != want {
+			t.Errorf("snakeToCamel(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	newStore := func() Store {
+		s := NewMemoryStore()
+		s.Add("abc", Link{URL: "https://example.com/"})
+		return s
+	}
+	strict := func() Store {
+		return NewNormalizingStore(newStore(), AliasNormalizer{strict: true})
+	}
+	tests := []struct {
+		name       string
+		h          http.Handler
+		method     string
+		hash       string
+		body       string
+		wantStatus int
+	}{
+		{name: "add malformed body", h: newTestAddPath(newStore()), method: "POST", body: `{"url":`, wantStatus: http.StatusBadRequest},
+		{name: "add invalid alias", h: newTestAddPath(newStore()), method: "POST", body: `{"url": "https://example.com/", "alias": "a b"}`, wantStatus: http.StatusBadRequest},
+		{name: "add duplicate alias", h: newTestAddPath(newStore()), method: "POST", body: `{"url": "https://example.org/", "alias": "abc"}`, wantStatus: http.StatusConflict},
+		{name: "add store failure", h: newTestAddPath(downStore{}), method: "POST", body: `{"url": "https://example.com/"}`, wantStatus: http.StatusInternalServerError},
+		{name: "redirect empty hash", h: &RedirectPath{store: newStore()}, method: "GET", wantStatus: http.StatusBadRequest},
+		{name: "redirect unknown", h: &RedirectPath{store: newStore()}, method: "GET", hash: "missing", wantStatus: http.StatusNotFound},
+		{name: "redirect strict invalid alias", h: &RedirectPath{store: strict()}, method: "GET", hash: "p\u0430ypal", wantStatus: http.StatusNotFound},
+		{name: "redirect store failure", h: &RedirectPath{store: downStore{}}, method: "GET", hash: "abc", wantStatus: http.StatusInternalServerError},
+		{name: "delete empty hash", h: &DeletePath{store: newStore()}, method: "DELETE", wantStatus: http.StatusBadRequest},
+		{name: "delete unknown", h: &DeletePath{store: newStore()}, method: "DELETE", hash: "missing", wantStatus: http.StatusNotFound},
+		{name: "delete strict invalid alias", h: &DeletePath{store: strict()}, method: "DELETE", hash: "p\u0430ypal", wantStatus: http.StatusBadRequest},
+		{name: "delete store failure", h: &DeletePath{store: downStore{}}, method: "DELETE", hash: "abc", wantStatus: http.StatusInternalServerError},
+		{name: "stats unknown", h: &StatsPath{store: newStore()}, method: "GET", hash: "missing", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := doHash(tt.h, tt.method, tt.hash, tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %v, want %v: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("content type = %q", ct)
+			}
+			body := decode(t, rec)
+			if msg, ok := body["error"].(string); !ok || msg == "" || len(body) != 1 {
+				t.Errorf("body = %v, want only a non-empty error", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaProducer is the part of *kafka.Writer that KafkaHook uses, so tests
+// can stand in for a broker.
+type kafkaProducer interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// OverflowPolicy decides what KafkaHook does with an event when its
+// buffer is full.
+type OverflowPolicy int
+
+const (
+	// OverflowDrop logs and drops the event.
+	OverflowDrop OverflowPolicy = iota
+	// OverflowBlock waits for room. Hooks run on their own goroutine, so
+	// this holds up the hook rather than the request.
+	OverflowBlock
+)
+
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch s {
+	case "drop":
+		return OverflowDrop, nil
+	case "block":
+		return OverflowBlock, nil
+	}
+	return OverflowDrop, fmt.Errorf("unknown overflow policy %q", s)
+}
+
+type kafkaEvent struct {
+	Type      string    `json:"type"`
+	Code      string    `json:"code"`
+	LongURL   string    `json:"long_url,omitempty"`
+	Time      time.Time `json:"time"`
+	Referer   string    `json:"referer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+const (
+	kafkaBatchSize    = 100
+	kafkaBatchTimeout = time.Second
+	kafkaWriteTimeout = 10 * time.Second
+)
+
+// KafkaHook publishes one JSON message per link operation, keyed by code.
+// Events are buffered and written in batches of up to kafkaBatchSize, or
+// whatever has arrived after kafkaBatchTimeout. A batch that cannot be
+// written is logged and dropped.
+type KafkaHook struct {
+	producer kafkaProducer
+	overflow OverflowPolicy
+	messages chan kafka.Message
+	done     chan struct{}
+
+	// mu guards closed so no message is sent after messages is closed.
+	mu     sync.RWMutex
+	closed bool
+}
+
+func (h *KafkaHook) OnAdd(e Event)      { h.publish("created", e) }
+func (h *KafkaHook) OnRemove(e Event)   { h.publish("deleted", e) }
+func (h *KafkaHook) OnRedirect(e Event) { h.publish("redirected", e) }
+func (h *KafkaHook) OnUpdate(e Event)   { h.publish("updated", e) }
+
+func (h *KafkaHook) publish(kind string, e Event) {
+	raw, err := json.Marshal(kafkaEvent{
+		Type:      kind,
+		Code:      e.Code,
+		LongURL:   e.LongURL,
+		Time:      e.Time.UTC(),
+		Referer:   e.Referer,
+		UserAgent: e.UserAgent,
+		RequestID: e.RequestID,
+	})
+	if err != nil {
+		return
+	}
+	msg := kafka.Message{Key: []byte(e.Code), Value: raw}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.closed {
+		return
+	}
+	if h.overflow == OverflowBlock {
+		h.messages <- msg
+		return
+	}
+	select {
+	case h.messages <- msg:
+	default:
+		log.Printf("kafka: buffer full, dropping %v event for %v", kind, e.Code)
+	}
+}
+
+func (h *KafkaHook) run() {
+	defer close(h.done)
+	batch := make([]kafka.Message, 0, kafkaBatchSize)
+	timer := time.NewTimer(kafkaBatchTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case msg, ok := <-h.messages:
+			if !ok {
+				h.write(batch)
+				return
+			}
+			batch = append(batch, msg)
+			if len(batch) < kafkaBatchSize {
+				continue
+			}
+		case <-timer.C:
+		}
+		h.write(batch)
+		batch = batch[:0]
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(kafkaBatchTimeout)
+	}
+}
+
+func (h *KafkaHook) write(batch []kafka.Message) {
+	if len(batch) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), kafkaWriteTimeout)
+	defer cancel()
+	err := h.producer.WriteMessages(ctx, batch...)
+	if err != nil {
+		log.Printf("kafka: unable to publish %v events: %v", len(batch), err)
+	}
+}
+
+// Close publishes buffered events and closes the producer. Events arriving
+// after Close are dropped.
+func (h *KafkaHook) Close() error {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return nil
+	}
+	h.closed = true
+	close(h.messages)
+	h.mu.Unlock()
+	<-h.done
+	return h.producer.Close()
+}
+
+// NewKafkaHook publishes events to topic on brokers, buffering up to
+// buffer of them.
+func NewKafkaHook(brokers []string, topic string, buffer int, overflow OverflowPolicy) *KafkaHook {
+	return newKafkaHook(&kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		BatchSize:    kafkaBatchSize,
+		BatchTimeout: 10 * time.Millisecond,
+	}, buffer, overflow)
+}
+
+func newKafkaHook(producer kafkaProducer, buffer int, overflow OverflowPolicy) *KafkaHook {
+	h := &KafkaHook{
+		producer: producer,
+		overflow: overflow,
+		messages: make(chan kafka.Message, buffer),
+		done:     make(chan struct{}),
+	}
+	go h.run()
+	return h
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// stubProducer records every batch written to it. While block is open,
+// writes wait for it to be closed.
+type stubProducer struct {
+	mu      sync.Mutex
+	batches [][]kafka.Message
+	closed  bool
+	block   chan struct{}
+}
+
+func (p *stubProducer) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if p.block != nil {
+		<-p.block
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.batches = append(p.batches, append([]kafka.Message(nil), msgs...))
+	return nil
+}
+
+func (p *stubProducer) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
+
+func (p *stubProducer) messages() []kafka.Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var all []kafka.Message
+	for _, b := range p.batches {
+		all = append(all, b...)
+	}
+	return all
+}
+
+func TestKafkaHookPublishes(t *testing.T) {
+	producer := &stubProducer{}
+	h := newKafkaHook(producer, 10, OverflowDrop)
+	at := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	h.OnAdd(Event{Code: "abc", LongURL: "https://example.com/", Time: at, RequestID: "req-1"})
+	h.OnRedirect(Event{Code: "abc", LongURL: "https://example.com/", Time: at, UserAgent: "curl"})
+	h.OnUpdate(Event{Code: "abc", LongURL: "https://example.org/", Time: at})
+	h.OnRemove(Event{Code: "abc", Time: at})
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		`{"type":"created","code":"abc","long_url":"https://example.com/","time":"2030-01-02T03:04:05Z","request_id":"req-1"}`,
+		`{"type":"redirected","code":"abc","long_url":"https://example.com/","time":"2030-01-02T03:04:05Z","user_agent":"curl"}`,
+		`{"type":"updated","code":"abc","long_url":"https://example.org/","time":"2030-01-02T03:04:05Z"}`,
+		`{"type":"deleted","code":"abc","time":"2030-01-02T03:04:05Z"}`,
+	}
+	msgs := producer.messages()
+	if len(msgs) != len(want) {
+		t.Fatalf("published %v messages, want %v", len(msgs), len(want))
+	}
+	for i, msg := range msgs {
+		if string(msg.Key) != "abc" {
+			t.Errorf("message %v key = %q, want the code", i, msg.Key)
+		}
+		if string(msg.Value) != want[i] {
+			t.Errorf("message %v = %s, want %s", i, msg.Value, want[i])
+		}
+	}
+	if !producer.closed {
+		t.Errorf("producer not closed")
+	}
+}
+
+func TestKafkaHookBatches(t *testing.T) {
+	producer := &stubProducer{}
+	h := newKafkaHook(producer, 1000, OverflowBlock)
+	const total = 2*kafkaBatchSize + 5
+	for i := 0; i < total; i++ {
+		h.OnAdd(Event{Code: fmt.Sprintf("c%03d", i)})
+	}
+	h.Close()
+
+	n := 0
+	for _, b := range producer.batches {
+		if len(b) > kafkaBatchSize {
+			t.Errorf("batch of %v, want at most %v", len(b), kafkaBatchSize)
+		}
+		for _, msg := range b {
+			var e kafkaEvent
+			if err := json.Unmarshal(msg.Value, &e); err != nil {
+				t.Fatal(err)
+			}
+			if want := fmt.Sprintf("c%03d", n); e.Code != want {
+				t.Fatalf("message %v is for %v, want %v", n, e.Code, want)
+			}
+			n++
+		}
+	}
+	if n != total {
+		t.Errorf("published %v messages, want %v", n, total)
+	}
+}
+
+func TestKafkaHookBatchTimeout(t *testing.T) {
+	producer := &stubProducer{}
+	h := newKafkaHook(producer, 10, OverflowDrop)
+	defer h.Close()
+	h.OnAdd(Event{Code: "abc"})
+	deadline := time.Now().Add(kafkaBatchTimeout + 2*time.Second)
+	for len(producer.messages()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("a partial batch was never published")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestKafkaHookOverflow(t *testing.T) {
+	tests := []struct {
+		name     string
+		overflow OverflowPolicy
+		want     int
+	}{
+		// One message is held by the blocked write and two fill the
+		// buffer; the rest are dropped.
+		{name: "drop", overflow: OverflowDrop, want: 3},
+		{name: "block", overflow: OverflowBlock, want: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			producer := &stubProducer{block: make(chan struct{})}
+			h := newKafkaHook(producer, 2, tt.overflow)
+			h.OnAdd(Event{Code: "c0"})
+			// Wait for run to take the first message and stall in the
+			// timed-out write.
+			time.Sleep(kafkaBatchTimeout + 100*time.Millisecond)
+
+			published := make(chan struct{})
+			go func() {
+				for i := 1; i < 10; i++ {
+					h.OnAdd(Event{Code: fmt.Sprintf("c%v", i)})
+				}
+				close(published)
+			}()
+			if tt.overflow == OverflowDrop {
+				<-published
+			}
+			close(producer.block)
+			<-published
+			h.Close()
+			if got := len(producer.messages()); got != tt.want {
+				t.Errorf("published %v messages, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseOverflowPolicy(t *testing.T) {
+	for s, want := range map[string]OverflowPolicy{"drop": OverflowDrop, "block": OverflowBlock} {
+		if got, err := ParseOverflowPolicy(s); err != nil || got != want {
+			t.Errorf("ParseOverflowPolicy(%q) = %v, %v", s, got, err)
+		}
+	}
+	if _, err := ParseOverflowPolicy("wait"); err == nil {
+		t.Errorf("unknown policy accepted")
+	}
+}
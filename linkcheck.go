@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Outcomes of a destination check, so a slow site can be told apart from a
+// dead one.
+const (
+	checkReachable = "reachable"
+	checkTimedOut  = "timed_out"
+	checkError     = "error"
+	checkNon2xx    = "non_2xx"
+)
+
+type linkCheckResult struct {
+	Code       string `json:"code"`
+	LongURL    string `json:"long_url,omitempty"`
+	Outcome    string `json:"outcome"`
+	StatusCode int    `json:"status_code,omitempty"`
+	Reachable  bool   `json:"reachable"`
+	Error      string `json:"error,omitempty"`
+}
+
+// CheckLinksPath issues HEAD requests to the destinations of the given codes
+// and reports whether each one is still reachable. Each request is bounded
+// by the client timeout, and slots caps the number of checks in flight
+// across all concurrent calls.
+type CheckLinksPath struct {
+	store  Store
+	client *http.Client
+	slots  chan struct{}
+}
+
+func (p *CheckLinksPath) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	type checkLinksRequest struct {
+		Codes []string `json:"codes"`
+	}
+
+	var parsed checkLinksRequest
+	err := json.NewDecoder(r.Body).Decode(&parsed)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("unable to parse request: %v", err))
+		return
+	}
+	if len(parsed.Codes) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "codes is empty")
+		return
+	}
+
+	results := make([]linkCheckResult, len(parsed.Codes))
+	var wg sync.WaitGroup
+	for i, code := range parsed.Codes {
+		wg.Add(1)
+		p.slots <- struct{}{}
+		go func(i int, code string) {
+			defer wg.Done()
+			defer func() { <-p.slots }()
+			results[i] = p.check(code)
+		}(i, code)
+	}
+	wg.Wait()
+
+	writeJSON(w, http.StatusOK, results)
+}
+
+func (p *CheckLinksPath) check(code string) linkCheckResult {
+	result := linkCheckResult{Code: code}
+	link, err := p.store.Get(code)
+	if err != nil {
+		result.Outcome = checkError
+		result.Error = err.Error()
+		return result
+	}
+	longURL := link.URL
+	result.LongURL = longURL
+
+	resp, err := p.client.Head(longURL)
+	if err == nil && resp.StatusCode == http.StatusMethodNotAllowed {
+		// Some servers refuse HEAD; ask again with GET before calling
+		// the destination dead.
+		resp.Body.Close()
+		resp, err = p.client.Get(longURL)
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		result.Outcome = checkTimedOut
+		result.Error = err.Error()
+		return result
+	}
+	if err != nil {
+		result.Outcome = checkError
+		result.Error = err.Error()
+		return result
+	}
+	resp.Body.Close()
+	result.StatusCode = resp.StatusCode
+	result.Reachable = resp.StatusCode < 400
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		result.Outcome = checkReachable
+	} else {
+		result.Outcome = checkNon2xx
+	}
+	return result
+}
+
+func NewCheckLinksPath(store Store, concurrency int, timeout time.Duration) *CheckLinksPath {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &CheckLinksPath{
+		store:  store,
+		client: &http.Client{Timeout: timeout},
+		slots:  make(chan struct{}, concurrency),
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newStubDestinations(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		// The client follows redirects, so this reports the 404.
+		http.Redirect(w, r, "/missing", http.StatusFound)
+	})
+	mux.HandleFunc("/no-head", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "HEAD" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestCheckLinks(t *testing.T) {
+	srv := newStubDestinations(t)
+	store := NewMemoryStore()
+	for _, path := range []string{"ok", "missing", "moved", "no-head", "slow"} {
+		store.Add(path, Link{URL: srv.URL + "/" + path})
+	}
+
+	tests := []struct {
+		code          string
+		wantOutcome   string
+		wantStatus    int
+		wantReachable bool
+	}{
+		{code: "ok", wantOutcome: checkReachable, wantStatus: 200, wantReachable: true},
+		{code: "missing", wantOutcome: checkNon2xx, wantStatus: 404},
+		{code: "moved", wantOutcome: checkNon2xx, wantStatus: 404},
+		{code: "no-head", wantOutcome: checkReachable, wantStatus: 200, wantReachable: true},
+		{code: "unknown", wantOutcome: checkError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			p := NewCheckLinksPath(store, 2, 5*time.Second)
+			rec := do(p, "POST", "/admin/check-links", fmt.Sprintf(`{"codes":[%q]}`, tt.code))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %v: %s", rec.Code, rec.Body)
+			}
+			var results []linkCheckResult
+			json.Unmarshal(rec.Body.Bytes(), &results)
+			if len(results) != 1 {
+				t.Fatalf("got %v results, want 1", len(results))
+			}
+			got := results[0]
+			if got.Outcome != tt.wantOutcome || got.StatusCode != tt.wantStatus || got.Reachable != tt.wantReachable {
+				t.Errorf("got %+v, want outcome %v, status %v, reachable %v", got, tt.wantOutcome, tt.wantStatus, tt.wantReachable)
+			}
+		})
+	}
+}
+
+func TestCheckLinksMixedBatch(t *testing.T) {
+	srv := newStubDestinations(t)
+	store := NewMemoryStore()
+	codes := []string{"ok", "missing", "no-head", "ok2"}
+	for _, code := range codes {
+		path := code
+		if code == "ok2" {
+			path = "ok"
+		}
+		store.Add(code, Link{URL: srv.URL + "/" + path})
+	}
+	p := NewCheckLinksPath(store, 2, 5*time.Second)
+	body, _ := json.Marshal(map[string][]string{"codes": codes})
+	rec := do(p, "POST", "/admin/check-links", string(body))
+	var results []linkCheckResult
+	json.Unmarshal(rec.Body.Bytes(), &results)
+	if len(results) != len(codes) {
+		t.Fatalf("got %v results, want %v", len(results), len(codes))
+	}
+	for i, code := range codes {
+		if results[i].Code != code {
+			t.Errorf("result %v is for %v, want %v", i, results[i].Code, code)
+		}
+	}
+}
+
+func TestCheckLinksTimeout(t *testing.T) {
+	srv := newStubDestinations(t)
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	store := NewMemoryStore()
+	store.Add("ok", Link{URL: srv.URL + "/ok"})
+	store.Add("missing", Link{URL: srv.URL + "/missing"})
+	store.Add("dead", Link{URL: dead.URL + "/"})
+	for i := 0; i < 4; i++ {
+		store.Add(fmt.Sprintf("slow%v", i), Link{URL: srv.URL + "/slow"})
+	}
+
+	tests := []struct {
+		name  string
+		codes []string
+		want  []string
+	}{
+		{name: "slow", codes: []string{"slow0"}, want: []string{checkTimedOut}},
+		{name: "refused", codes: []string{"dead"}, want: []string{checkError}},
+		{
+			name:  "slow destinations do not stall the batch",
+			codes: []string{"slow0", "ok", "slow1", "missing", "slow2", "dead", "slow3"},
+			want:  []string{checkTimedOut, checkReachable, checkTimedOut, checkNon2xx, checkTimedOut, checkError, checkTimedOut},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewCheckLinksPath(store, 2, 100*time.Millisecond)
+			body, _ := json.Marshal(map[string][]string{"codes": tt.codes})
+			start := time.Now()
+			rec := do(p, "POST", "/admin/check-links", string(body))
+			// Each slow destination sleeps a full second; with two slots
+			// and a 100ms timeout the batch finishes well before that.
+			if d := time.Since(start); d >= time.Second {
+				t.Errorf("batch took %v", d)
+			}
+			var results []linkCheckResult
+			json.Unmarshal(rec.Body.Bytes(), &results)
+			if len(results) != len(tt.want) {
+				t.Fatalf("got %v results, want %v", len(results), len(tt.want))
+			}
+			for i, want := range tt.want {
+				if results[i].Outcome != want {
+					t.Errorf("%v: outcome = %v, want %v (%v)", results[i].Code, results[i].Outcome, want, results[i].Error)
+				}
+			}
+		})
+	}
+}
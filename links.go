@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 500
+)
+
+// LinkRecord is a link together with its code, as returned by Store.List.
+// It embeds Link's MarshalJSON, so it is not encoded directly.
+type LinkRecord struct {
+	Hash string
+	Link
+}
+
+// listPage returns up to limit unexpired links from items, skipping the
+// first offset in hash order.
+func listPage(items map[string]Link, now time.Time, limit, offset int) []LinkRecord {
+	var hashes []string
+	for hash, link := range items {
+		if !link.Expired(now) {
+			hashes = append(hashes, hash)
+		}
+	}
+	sort.Strings(hashes)
+	if offset >= len(hashes) {
+		return nil
+	}
+	hashes = hashes[offset:]
+	if limit < len(hashes) {
+		hashes = hashes[:limit]
+	}
+	records := make([]LinkRecord, 0, len(hashes))
+	for _, hash := range hashes {
+		records = append(records, LinkRecord{Hash: hash, Link: items[hash]})
+	}
+	return records
+}
+
+// LinksPath pages through every stored link in hash order. Repeated tag
+// parameters keep only links carrying all of them, or any of them with
+// match=any.
+type LinksPath struct {
+	domain string
+	store  Store
+}
+
+func (p *LinksPath) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	limit, ok := nonNegativeParam(r, "limit", defaultListLimit)
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "limit must be a non-negative integer")
+		return
+	}
+	offset, ok := nonNegativeParam(r, "offset", 0)
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "offset must be a non-negative integer")
+		return
+	}
+	if limit > maxListLimit {
+		limit = maxListLimit
+	}
+
+	tags, err := normalizeTags(r.URL.Query()["tag"])
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	match := r.URL.Query().Get("match")
+	if match != "" && match != "all" && match != "any" {
+		writeJSONError(w, http.StatusBadRequest, "match must be all or any")
+		return
+	}
+
+	var records []LinkRecord
+	if len(tags) == 0 {
+		records, err = p.store.List(limit, offset)
+	} else {
+		ts, ok := p.store.(TagStore)
+		if !ok {
+			writeJSONError(w, http.StatusNotImplemented, "the configured store cannot filter by tag")
+			return
+		}
+		records, err = ts.ListTagged(tags, match != "any", limit, offset)
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("unexpected error: %v", err))
+		return
+	}
+
+	type linkResponse struct {
+		Hash     string   `json:"hash"`
+		ShortURL string   `json:"short_url"`
+		LongURL  string   `json:"long_url"`
+		Tags     []string `json:"tags,omitempty"`
+	}
+	resp := make([]linkResponse, 0, len(records))
+	for _, rec := range records {
+		resp = append(resp, linkResponse{
+			Hash:     rec.Hash,
+			ShortURL: shortURL(p.domain, rec.Hash),
+			LongURL:  rec.URL,
+			Tags:     rec.Tags,
+		})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// nonNegativeParam reads query parameter name, returning fallback when it
+// is absent and false when it is not a non-negative integer.
+func nonNegativeParam(r *http.Request, name string, fallback int) (int, bool) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return fallback, true
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
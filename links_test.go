@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+type listedLink struct {
+	Hash     string `json:"hash"`
+	ShortURL string `json:"short_url"`
+	LongURL  string `json:"long_url"`
+}
+
+func listLinks(t *testing.T, p *LinksPath, query string) (int, []listedLink) {
+	t.Helper()
+	rec := do(p, "GET", "/links"+query, "")
+	var links []listedLink
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &links); err != nil {
+			t.Fatalf("%v: %s", err, rec.Body)
+		}
+	}
+	return rec.Code, links
+}
+
+func TestLinksPath(t *testing.T) {
+	const total = 120
+	stores := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store { return NewMemoryStore() },
+		"file":   func(t *testing.T) Store { return newTestFileStore(t, 0) },
+		"sql":    func(t *testing.T) Store { return newTestSQLStore(t) },
+	}
+	tests := []struct {
+		query      string
+		wantStatus int
+		wantCount  int
+		wantFirst  string
+	}{
+		{query: "", wantStatus: http.StatusOK, wantCount: defaultListLimit, wantFirst: "c000"},
+		{query: "?limit=10", wantStatus: http.StatusOK, wantCount: 10, wantFirst: "c000"},
+		{query: "?limit=10&offset=115", wantStatus: http.StatusOK, wantCount: 5, wantFirst: "c115"},
+		{query: "?offset=200", wantStatus: http.StatusOK, wantCount: 0},
+		{query: "?limit=0", wantStatus: http.StatusOK, wantCount: 0},
+		{query: "?limit=-1", wantStatus: http.StatusBadRequest},
+		{query: "?limit=ten", wantStatus: http.StatusBadRequest},
+		{query: "?offset=-5", wantStatus: http.StatusBadRequest},
+		{query: "?offset=1.5", wantStatus: http.StatusBadRequest},
+	}
+	for storeName, newStore := range stores {
+		store := newStore(t)
+		// Added out of order to show the listing is sorted by hash.
+		for i := total - 1; i >= 0; i-- {
+			mustAdd(t, store, fmt.Sprintf("c%03d", i), fmt.Sprintf("https://example.com/%v", i))
+		}
+		p := &LinksPath{domain: "sho.rt", store: store}
+		for _, tt := range tests {
+			t.Run(storeName+"/"+tt.query, func(t *testing.T) {
+				status, links := listLinks(t, p, tt.query)
+				if status != tt.wantStatus {
+					t.Fatalf("status = %v, want %v", status, tt.wantStatus)
+				}
+				if len(links) != tt.wantCount {
+					t.Fatalf("got %v links, want %v", len(links), tt.wantCount)
+				}
+				if tt.wantCount > 0 && links[0].Hash != tt.wantFirst {
+					t.Errorf("first hash = %v, want %v", links[0].Hash, tt.wantFirst)
+				}
+			})
+		}
+		t.Run(storeName+"/pages cover every link once", func(t *testing.T) {
+			var all []listedLink
+			for offset := 0; ; offset += 7 {
+				_, page := listLinks(t, p, fmt.Sprintf("?limit=7&offset=%v", offset))
+				if len(page) == 0 {
+					break
+				}
+				all = append(all, page...)
+			}
+			if len(all) != total {
+				t.Fatalf("got %v links across pages, want %v", len(all), total)
+			}
+			for i, l := range all {
+				want := listedLink{
+					Hash:     fmt.Sprintf("c%03d", i),
+					ShortURL: fmt.Sprintf("https://sho.rt/c%03d", i),
+					LongURL:  fmt.Sprintf("https://example.com/%v", i),
+				}
+				if l != want {
+					t.Errorf("link %v = %+v, want %+v", i, l, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLinksPathLimitCapped(t *testing.T) {
+	store := NewMemoryStore()
+	for i := 0; i < maxListLimit+10; i++ {
+		mustAdd(t, store, fmt.Sprintf("c%04d", i), "https://example.com/")
+	}
+	_, links := listLinks(t, &LinksPath{domain: "sho.rt", store: store}, "?limit=100000")
+	if len(links) != maxListLimit {
+		t.Errorf("got %v links, want the cap of %v", len(links), maxListLimit)
+	}
+}
@@ -1,253 +1,1903 @@
 package main
 
 import (
-	"crypto/sha1"
-	"encoding/hex"
+	"container/list"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math"
+	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
 
 	"github.com/gorilla/mux"
 )
 
+// Link is what a Store keeps for each code.
+type Link struct {
+	URL string `json:"url"`
+	// ExpiresAt is zero for links that never expire.
+	ExpiresAt time.Time `json:"expires_at"`
+	// Hits counts redirects through the link.
+	Hits int64 `json:"hits,omitempty"`
+	// Interstitial shows the interstitial page before redirecting, even
+	// when -interstitial is off.
+	Interstitial bool `json:"interstitial,omitempty"`
+	// RedirectRate and RedirectBurst override -link-rate and -link-burst
+	// for this link when non-zero.
+	RedirectRate  float64 `json:"redirect_rate,omitempty"`
+	RedirectBurst int     `json:"redirect_burst,omitempty"`
+	// Tags are kept sorted and free of duplicates (see normalizeTags).
+	Tags []string `json:"tags,omitempty"`
+}
+
+// MarshalJSON leaves out expires_at for links that never expire.
+func (l Link) MarshalJSON() ([]byte, error) {
+	type plainLink Link
+	return json.Marshal(struct {
+		plainLink
+		ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	}{plainLink(l), optionalTime(l.ExpiresAt)})
+}
+
+// optionalTime returns nil for the zero time, so that omitempty drops it.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// Expired reports whether the link has expired as of now. Stores treat
+// expired links as if they did not exist.
+func (l Link) Expired(now time.Time) bool {
+	return !l.ExpiresAt.IsZero() && !now.Before(l.ExpiresAt)
+}
+
 type Store interface {
-	Add(shortenedURL, longURL string) error
+	Add(shortenedURL string, link Link) error
 	Remove(shortenedURL string) error
-	Get(shortenedURL string) (string, error)
+	Get(shortenedURL string) (Link, error)
+	// GetByURL returns every code pointing at longURL, sorted.
+	GetByURL(longURL string) ([]string, error)
+	// List returns up to limit links sorted by code, skipping the first
+	// offset.
+	List(limit, offset int) ([]LinkRecord, error)
+}
+
+// ErrNotFound is returned by stores for codes that do not exist or have
+// expired.
+var ErrNotFound = errors.New("shortened URL does not exist")
+
+// ErrAlreadyExists is returned by Store.Add when the code is taken.
+var ErrAlreadyExists = errors.New("shortened URL already exists")
+
+// Tombstoner is implemented by stores that remember which codes were
+// removed, so a deleted link can be told apart from one that never existed.
+type Tombstoner interface {
+	WasRemoved(shortenedURL string) (bool, error)
+}
+
+// EvictionPolicy picks which entry a bounded MemoryStore drops when full.
+type EvictionPolicy int
+
+const (
+	// EvictOldest drops the entry that was added first.
+	EvictOldest EvictionPolicy = iota
+	// EvictLRU drops the entry that was least recently read or added.
+	EvictLRU
+)
+
+func ParseEvictionPolicy(s string) (EvictionPolicy, error) {
+	switch s {
+	case "oldest":
+		return EvictOldest, nil
+	case "lru":
+		return EvictLRU, nil
+	}
+	return EvictOldest, fmt.Errorf("unknown eviction policy %q", s)
 }
 
 type MemoryStore struct {
-	items map[string]string
+	mu    sync.Mutex
+	items map[string]Link
+	// removed holds tombstones; removedOrder lists them oldest first so a
+	// bounded store can drop the oldest once there are maxEntries of them.
+	removed      map[string]*list.Element
+	removedOrder *list.List
+	// order tracks codes from most to least recently used (or added, for
+	// EvictOldest) when maxEntries is set.
+	order      *list.List
+	elems      map[string]*list.Element
+	maxEntries int
+	policy     EvictionPolicy
 }
 
-func (m *MemoryStore) Add(shortenedURL, longURL string) error {
-	if m.items[shortenedURL] != "" {
-		return fmt.Errorf("shortened URL already exists")
+func (m *MemoryStore) Add(shortenedURL string, link Link) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.live(shortenedURL) {
+		return ErrAlreadyExists
+	}
+	if m.maxEntries > 0 {
+		for len(m.items) >= m.maxEntries {
+			m.evict()
+		}
+		m.elems[shortenedURL] = m.order.PushFront(shortenedURL)
 	}
-	m.items[shortenedURL] = longURL
-	log.Println(m.items)
+	m.items[shortenedURL] = link
+	m.untombstone(shortenedURL)
 	return nil
 }
 
+// evict drops the entry at the back of m.order. m.mu must be held.
+func (m *MemoryStore) evict() {
+	e := m.order.Back()
+	if e == nil {
+		return
+	}
+	code := m.order.Remove(e).(string)
+	delete(m.elems, code)
+	delete(m.items, code)
+}
+
+// tombstone records code as removed, dropping the oldest tombstone when a
+// bounded store already holds maxEntries of them. m.mu must be held.
+func (m *MemoryStore) tombstone(code string) {
+	if _, ok := m.removed[code]; ok {
+		return
+	}
+	m.removed[code] = m.removedOrder.PushBack(code)
+	if m.maxEntries > 0 && m.removedOrder.Len() > m.maxEntries {
+		oldest := m.removedOrder.Remove(m.removedOrder.Front()).(string)
+		delete(m.removed, oldest)
+	}
+}
+
+// untombstone forgets that code was removed. m.mu must be held.
+func (m *MemoryStore) untombstone(code string) {
+	if e, ok := m.removed[code]; ok {
+		m.removedOrder.Remove(e)
+		delete(m.removed, code)
+	}
+}
+
+// live reports whether code holds an unexpired link, dropping it if it has
+// expired. m.mu must be held.
+func (m *MemoryStore) live(code string) bool {
+	link, ok := m.items[code]
+	if !ok {
+		return false
+	}
+	if !link.Expired(time.Now()) {
+		return true
+	}
+	delete(m.items, code)
+	if e, ok := m.elems[code]; ok {
+		m.order.Remove(e)
+		delete(m.elems, code)
+	}
+	return false
+}
+
 func (m *MemoryStore) Remove(shortenedURL string) error {
-	if m.items[shortenedURL] == "" {
-		return fmt.Errorf("shortened URL does not exist")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.live(shortenedURL) {
+		return ErrNotFound
 	}
 	delete(m.items, shortenedURL)
-	log.Println(m.items)
+	if e, ok := m.elems[shortenedURL]; ok {
+		m.order.Remove(e)
+		delete(m.elems, shortenedURL)
+	}
+	m.tombstone(shortenedURL)
 	return nil
 }
 
-func (m *MemoryStore) Get(shortenedURL string) (string, error) {
-	longURL, ok := m.items[shortenedURL]
-	if !ok {
-		return "", fmt.Errorf("shortened URL does not exist")
+func (m *MemoryStore) WasRemoved(shortenedURL string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.removed[shortenedURL]
+	return ok, nil
+}
+
+func (m *MemoryStore) GetByURL(longURL string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var codes []string
+	now := time.Now()
+	for code, link := range m.items {
+		if link.URL == longURL && !link.Expired(now) {
+			codes = append(codes, code)
+		}
 	}
-	return longURL, nil
+	sort.Strings(codes)
+	return codes, nil
+}
+
+func (m *MemoryStore) List(limit, offset int) ([]LinkRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return listPage(m.items, time.Now(), limit, offset), nil
+}
+
+func (m *MemoryStore) ListTagged(tags []string, all bool, limit, offset int) ([]LinkRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return listPage(tagged(m.items, tags, all), time.Now(), limit, offset), nil
+}
+
+func (m *MemoryStore) TagCounts() (map[string]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return countTags(m.items, time.Now()), nil
+}
+
+func (m *MemoryStore) Get(shortenedURL string) (Link, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.live(shortenedURL) {
+		return Link{}, ErrNotFound
+	}
+	if m.policy == EvictLRU {
+		if e, ok := m.elems[shortenedURL]; ok {
+			m.order.MoveToFront(e)
+		}
+	}
+	return m.items[shortenedURL], nil
+}
+
+func (m *MemoryStore) Rewrite(fn func(code, longURL string) (string, bool, error), dryRun bool) ([]rewriteChange, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var changes []rewriteChange
+	now := time.Now()
+	for code, link := range m.items {
+		if link.Expired(now) {
+			continue
+		}
+		rewritten, ok, err := fn(code, link.URL)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		changes = append(changes, rewriteChange{Code: code, From: link.URL, To: rewritten})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Code < changes[j].Code })
+	if !dryRun {
+		for _, c := range changes {
+			link := m.items[c.Code]
+			link.URL = c.To
+			m.items[c.Code] = link
+		}
+	}
+	return changes, nil
+}
+
+func (m *MemoryStore) Hit(shortenedURL string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.live(shortenedURL) {
+		return ErrNotFound
+	}
+	link := m.items[shortenedURL]
+	link.Hits++
+	m.items[shortenedURL] = link
+	return nil
+}
+
+func (m *MemoryStore) Len() (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.items), nil
 }
 
 func NewMemoryStore() *MemoryStore {
+	return NewBoundedMemoryStore(0, EvictOldest)
+}
+
+// NewBoundedMemoryStore returns a MemoryStore holding at most maxEntries
+// links, evicting according to policy, and at most as many tombstones of
+// removed codes, dropping the oldest first. maxEntries of 0 means unbounded.
+func NewBoundedMemoryStore(maxEntries int, policy EvictionPolicy) *MemoryStore {
 	return &MemoryStore{
-		items: make(map[string]string),
+		items:        make(map[string]Link),
+		removed:      make(map[string]*list.Element),
+		removedOrder: list.New(),
+		order:        list.New(),
+		elems:        make(map[string]*list.Element),
+		maxEntries:   maxEntries,
+		policy:       policy,
 	}
 }
 
 type AddPath struct {
-	domain string
-	store  Store
+	domain      string
+	store       Store
+	generator   CodeGenerator
+	httpsOnly   bool
+	upgradeHTTP bool
+	hooks       Hooks
+	duplicates  DuplicatePolicy
+	// allowPatterns, when non-empty, restricts which destinations may be
+	// shortened.
+	allowPatterns AllowPatterns
+	// allowEncrypted accepts client-encrypted destinations (see
+	// encrypted.go).
+	allowEncrypted bool
+	// minLength and maxLength bound the per-request length override.
+	minLength int
+	maxLength int
 }
 
 func (a *AddPath) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	type addPathRequest struct {
-		URL string `json:"url"`
+		URL        string `json:"url"`
+		Ciphertext string `json:"ciphertext"`
+		Alias      string `json:"alias"`
+		Length     int    `json:"length"`
+		TTLSeconds int64  `json:"ttl_seconds"`
+		// Interstitial shows the interstitial page for this link.
+		Interstitial bool     `json:"interstitial"`
+		Tags         []string `json:"tags"`
+		// RedirectRate and RedirectBurst size this link's redirect
+		// token bucket.
+		RedirectRate  float64 `json:"redirect_rate"`
+		RedirectBurst int     `json:"redirect_burst"`
 	}
 
 	var parsed addPathRequest
 	err := json.NewDecoder(r.Body).Decode(&parsed)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("unexpected error: %v", err)))
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("unable to parse request: %v", err))
 		return
 	}
 
-	h := sha1.New()
-	h.Write([]byte(parsed.URL))
-	sum := h.Sum(nil)
-	hash := hex.EncodeToString(sum)[:10]
+	if parsed.Alias != "" && !validAlias(parsed.Alias) {
+		writeJSONError(w, http.StatusBadRequest, "alias may only contain letters, digits, '-' and '_', and must not be a reserved path")
+		return
+	}
+	if parsed.Alias != "" && parsed.Length != 0 {
+		writeJSONError(w, http.StatusBadRequest, "length cannot be combined with alias")
+		return
+	}
+	if parsed.TTLSeconds < 0 {
+		writeJSONError(w, http.StatusBadRequest, "ttl_seconds must not be negative")
+		return
+	}
+	if parsed.RedirectRate < 0 || parsed.RedirectBurst < 0 {
+		writeJSONError(w, http.StatusBadRequest, "redirect_rate and redirect_burst must not be negative")
+		return
+	}
+	parsed.Tags, err = normalizeTags(parsed.Tags)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
-	err = a.store.Add(hash, parsed.URL)
+	if parsed.Ciphertext != "" {
+		if !a.allowEncrypted {
+			writeJSONError(w, http.StatusBadRequest, "encrypted destinations are not enabled")
+			return
+		}
+		if parsed.URL != "" || !validCiphertext(parsed.Ciphertext) {
+			writeJSONError(w, http.StatusBadRequest, "ciphertext must be unpadded base64url and sent without url")
+			return
+		}
+		parsed.URL = encryptedPrefix + parsed.Ciphertext
+	} else {
+		parsed.URL, err = a.destination(parsed.URL)
+		if errors.Is(err, ErrDestinationNotAllowed) {
+			writeJSONError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	link := Link{
+		URL:           parsed.URL,
+		Interstitial:  parsed.Interstitial,
+		RedirectRate:  parsed.RedirectRate,
+		RedirectBurst: parsed.RedirectBurst,
+		Tags:          parsed.Tags,
+	}
+	if parsed.TTLSeconds > 0 {
+		link.ExpiresAt = time.Now().Add(time.Duration(parsed.TTLSeconds) * time.Second).UTC()
+	}
 
+	existing, found, ok, err := a.existing(parsed.Alias, link)
+	if errors.Is(err, ErrDuplicateDestination) {
+		writeJSONError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("unexpected error: %v", err))
+		return
+	}
+	if ok {
+		a.writeResponse(w, http.StatusOK, existing, found)
+		return
+	}
+
+	hash := parsed.Alias
+	if hash == "" {
+		hash, err = a.code(parsed.URL, parsed.Length)
+		var reqErr requestError
+		if errors.As(err, &reqErr) {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("unexpected error: %v", err))
+			return
+		}
+	}
+
+	created := true
+	if parsed.Alias == "" {
+		hash, created, err = a.addGenerated(hash, link)
+	} else {
+		err = a.store.Add(hash, link)
+	}
+	if errors.Is(err, ErrAlreadyExists) {
+		writeJSONError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if errors.Is(err, ErrInsufficientStorage) {
+		writeJSONError(w, http.StatusInsufficientStorage, err.Error())
+		return
+	}
+	if errors.Is(err, ErrInvalidAlias) {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if errors.Is(err, ErrStoreBusy) {
+		writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("unexpected error: %v", err)))
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("unexpected error: %v", err))
 		return
 	}
 
+	if !created {
+		a.writeResponse(w, http.StatusOK, hash, link)
+		return
+	}
+	a.hooks.Add(newEvent(r, hash, parsed.URL))
+	a.writeResponse(w, http.StatusCreated, hash, link)
+}
+
+func (a *AddPath) writeResponse(w http.ResponseWriter, status int, hash string, link Link) {
 	type addPathResponse struct {
-		ShortenedURL string `json:"shortened_url"`
-		LongURL      string `json:"long_url"`
+		ShortenedURL  string     `json:"shortened_url"`
+		LongURL       string     `json:"long_url"`
+		ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+		Interstitial  bool       `json:"interstitial,omitempty"`
+		RedirectRate  float64    `json:"redirect_rate,omitempty"`
+		RedirectBurst int        `json:"redirect_burst,omitempty"`
+		Tags          []string   `json:"tags,omitempty"`
 	}
 	pathResp := addPathResponse{
-		ShortenedURL: fmt.Sprintf("%v/%v", a.domain, hash),
-		LongURL:      parsed.URL,
+		ShortenedURL:  shortURL(a.domain, hash),
+		LongURL:       link.URL,
+		ExpiresAt:     optionalTime(link.ExpiresAt),
+		Interstitial:  link.Interstitial,
+		RedirectRate:  link.RedirectRate,
+		RedirectBurst: link.RedirectBurst,
+		Tags:          link.Tags,
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(pathResp)
-	fmt.Fprintf(w, "%s", hash)
+	writeJSON(w, status, pathResp)
+}
+
+// DuplicatePolicy decides what AddPath does with a destination that already
+// has a short link.
+type DuplicatePolicy int
+
+const (
+	// DuplicatesAllow creates another code for the destination. With a
+	// deterministic generator this yields the same code, so a permanent
+	// link is reused and the request answers 200 (see addGenerated).
+	DuplicatesAllow DuplicatePolicy = iota
+	// DuplicatesDedupe returns the existing code instead.
+	DuplicatesDedupe
+	// DuplicatesReject refuses the request with 409 Conflict.
+	DuplicatesReject
+)
+
+func ParseDuplicatePolicy(s string) (DuplicatePolicy, error) {
+	switch s {
+	case "allow":
+		return DuplicatesAllow, nil
+	case "dedupe":
+		return DuplicatesDedupe, nil
+	case "reject":
+		return DuplicatesReject, nil
+	}
+	return DuplicatesAllow, fmt.Errorf("unknown duplicate policy %q", s)
+}
+
+// reservedAliases are paths served by other routes, so a link stored
+// under one of them could never be followed.
+var reservedAliases = map[string]bool{
+	"add":       true,
+	"admin":     true,
+	"available": true,
+	"codes":     true,
+	"compute":   true,
+	"healthz":   true,
+	"links":     true,
+	"metrics":   true,
+	"tags":      true,
+}
+
+// validAlias reports whether alias only uses letters, digits, '-' and '_'
+// and does not shadow another route. Letters and digits may come from any
+// script, along with combining marks so decomposed (NFD) input is
+// accepted; the NormalizingStore in front of every store maps such
+// variants to one code.
+func validAlias(alias string) bool {
+	if reservedAliases[strings.ToLower(alias)] {
+		return false
+	}
+	for _, c := range alias {
+		switch {
+		case unicode.IsLetter(c), unicode.IsDigit(c), unicode.Is(unicode.Mn, c), c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// requestError reports a problem with what the client asked for, as
+// opposed to a server fault.
+type requestError string
+
+func (e requestError) Error() string {
+	return string(e)
+}
+
+// ErrDestinationNotAllowed is returned for destinations that match none of
+// the configured allow patterns.
+var ErrDestinationNotAllowed = errors.New("destination is not allowed")
+
+// destination applies the configured scheme policy and allow patterns to
+// longURL.
+func (a *AddPath) destination(longURL string) (string, error) {
+	if a.upgradeHTTP && strings.HasPrefix(strings.ToLower(longURL), "http://") {
+		longURL = "https://" + longURL[len("http://"):]
+	}
+	// Only the ciphertext field may produce an encrypted destination;
+	// otherwise a plain url could smuggle one past -allow-encrypted.
+	if isEncrypted(longURL) {
+		return "", requestError(fmt.Sprintf("destinations starting with %q are reserved for ciphertext", encryptedPrefix))
+	}
+	if a.httpsOnly {
+		u, err := url.Parse(longURL)
+		if err != nil || u.Scheme != "https" {
+			return "", requestError("only https destinations are allowed")
+		}
+	}
+	if !a.allowPatterns.Allowed(longURL) {
+		return "", ErrDestinationNotAllowed
+	}
+	return longURL, nil
+}
+
+// ErrDuplicateDestination is returned with DuplicatesReject when the
+// destination already has a short link.
+var ErrDuplicateDestination = errors.New("destination already has a short link")
+
+// existing applies the duplicate policy to link before it is added under
+// alias (empty for a generated code). With DuplicatesDedupe it returns the
+// code and link already stored for the destination, and ok set.
+func (a *AddPath) existing(alias string, link Link) (code string, found Link, ok bool, err error) {
+	if a.duplicates == DuplicatesAllow {
+		return "", Link{}, false, nil
+	}
+	codes, err := a.store.GetByURL(link.URL)
+	if err != nil {
+		return "", Link{}, false, err
+	}
+	if len(codes) > 0 && a.duplicates == DuplicatesReject {
+		return "", Link{}, false, ErrDuplicateDestination
+	}
+	// An explicit alias or a TTL asks for a new link, so only dedupe
+	// permanent generated ones.
+	if len(codes) == 0 || alias != "" || !link.ExpiresAt.IsZero() {
+		return "", Link{}, false, nil
+	}
+	found, err = a.store.Get(codes[0])
+	if err != nil {
+		return "", Link{}, false, err
+	}
+	return codes[0], found, true, nil
+}
+
+// code generates the short code for longURL. A non-zero length overrides
+// the generator's default length.
+func (a *AddPath) code(longURL string, length int) (string, error) {
+	if length == 0 {
+		return a.generator.Generate(longURL)
+	}
+	lg, ok := a.generator.(LengthGenerator)
+	if !ok {
+		return "", requestError("the configured generator does not support a length override")
+	}
+	if length < a.minLength || length > a.maxLength {
+		return "", requestError(fmt.Sprintf("length must be between %v and %v", a.minLength, a.maxLength))
+	}
+	return lg.GenerateLength(longURL, length)
+}
+
+// addGenerated stores link under a generated hash. If hash is already
+// taken by the same permanent destination the existing link is reused and
+// created is false. If it belongs to another destination and the generator is
+// deterministic, the code is extended one character at a time until a
+// free one is found.
+func (a *AddPath) addGenerated(hash string, link Link) (string, bool, error) {
+	lg, extendable := a.generator.(LengthGenerator)
+	if _, ok := a.generator.(DeterministicGenerator); !ok {
+		extendable = false
+	}
+	for {
+		err := a.store.Add(hash, link)
+		if !errors.Is(err, ErrAlreadyExists) {
+			return hash, err == nil, err
+		}
+		existing, getErr := a.store.Get(hash)
+		if getErr == nil && existing.URL == link.URL && existing.ExpiresAt.IsZero() && link.ExpiresAt.IsZero() {
+			return hash, false, nil
+		}
+		if !extendable {
+			return hash, false, err
+		}
+		longer, genErr := lg.GenerateLength(link.URL, len(hash)+1)
+		if genErr != nil {
+			// The full digest is taken too; nothing left to extend.
+			return hash, false, err
+		}
+		hash = longer
+	}
+}
+
+// shortURL joins the configured domain (which may carry a base path) and
+// code into a well-formed URL, tolerating trailing slashes and defaulting
+// the scheme to https when the domain has none.
+func shortURL(domain, code string) string {
+	if !strings.Contains(domain, "://") {
+		domain = "https://" + domain
+	}
+	u, err := url.Parse(domain)
+	if err != nil {
+		return strings.TrimRight(domain, "/") + "/" + url.PathEscape(code)
+	}
+	u.Path = strings.TrimRight(u.Path, "/") + "/" + code
+	u.RawPath = ""
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
+
+// ComputePath reports the code AddPath would assign to a URL without
+// storing it. It only makes sense for deterministic generators.
+type ComputePath struct {
+	add *AddPath
+}
+
+func (p *ComputePath) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, ok := p.add.generator.(DeterministicGenerator); !ok {
+		writeJSONError(w, http.StatusBadRequest, "the configured generator is not deterministic")
+		return
+	}
+	longURL := r.URL.Query().Get("url")
+	if longURL == "" {
+		writeJSONError(w, http.StatusBadRequest, "url is empty")
+		return
+	}
+	var length int
+	if raw := r.URL.Query().Get("length"); raw != "" {
+		var err error
+		length, err = strconv.Atoi(raw)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "length must be an integer")
+			return
+		}
+	}
+
+	longURL, err := p.add.destination(longURL)
+	if errors.Is(err, ErrDestinationNotAllowed) {
+		writeJSONError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	hash, err := p.add.code(longURL, length)
+	var reqErr requestError
+	if errors.As(err, &reqErr) {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("unexpected error: %v", err))
+		return
+	}
+
+	type computePathResponse struct {
+		Code         string `json:"code"`
+		ShortenedURL string `json:"shortened_url"`
+		LongURL      string `json:"long_url"`
+	}
+	writeJSON(w, http.StatusOK, computePathResponse{
+		Code:         hash,
+		ShortenedURL: shortURL(p.add.domain, hash),
+		LongURL:      longURL,
+	})
 }
 
 type DeletePath struct {
 	store Store
+	hooks Hooks
 }
 
 func (p *DeletePath) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	hash := mux.Vars(r)["hash"]
 
 	if hash == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("shortened URL is empty"))
+		writeJSONError(w, http.StatusBadRequest, "shortened URL is empty")
 		return
 	}
 
 	err := p.store.Remove(hash)
+	if errors.Is(err, ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if errors.Is(err, ErrInvalidAlias) {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if errors.Is(err, ErrInsufficientStorage) {
+		writeJSONError(w, http.StatusInsufficientStorage, err.Error())
+		return
+	}
+	if errors.Is(err, ErrStoreBusy) {
+		writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("unexpected error: %v", err)))
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("unexpected error: %v", err))
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("deleted"))
+	p.hooks.Remove(newEvent(r, hash, ""))
+	writeJSON(w, http.StatusOK, struct {
+		Deleted string `json:"deleted"`
+	}{Deleted: hash})
 }
 
 type RedirectPath struct {
-	store Store
+	store          Store
+	goneForRemoved bool
+	queryMode      QueryMode
+	hooks          Hooks
+	redirectRules  RedirectRules
+	redirectStatus int
+	// interstitial shows the interstitial page for every link; links
+	// created with "interstitial": true get it regardless.
+	interstitial      bool
+	interstitialDelay time.Duration
+	// limiter, when set, caps how often each code can be followed, using
+	// the link's own rate and burst where it has them.
+	limiter *KeyedLimiter
+	// analytics, when set, receives every followed link.
+	analytics AnalyticsStore
 }
 
 func (p *RedirectPath) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	hash := mux.Vars(r)["hash"]
 	if hash == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("shortened URL is empty"))
+		writeJSONError(w, http.StatusBadRequest, "shortened URL is empty")
+		return
+	}
+	link, err := p.store.Get(hash)
+	if errors.Is(err, ErrNotFound) && p.goneForRemoved && p.wasRemoved(hash) {
+		writeJSONError(w, http.StatusGone, "gone")
+		return
+	}
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrInvalidAlias) {
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("unexpected error: %v", err))
+		return
+	}
+	if p.limiter != nil {
+		ok, retryAfter := p.limiter.AllowOverride(hash, link.RedirectRate, link.RedirectBurst)
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			writeJSONError(w, http.StatusTooManyRequests, "too many requests for this link")
+			return
+		}
+	}
+	longURL := link.URL
+	if isEncrypted(longURL) {
+		e := newEvent(r, hash, longURL)
+		p.hooks.Redirect(e)
+		p.countHit(hash)
+		p.recordClick(e)
+		writeDecryptPage(w, strings.TrimPrefix(longURL, encryptedPrefix))
+		return
+	}
+	longURL, err = forwardQuery(longURL, r.URL.RawQuery, p.queryMode)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid query string: %v", err))
+		return
+	}
+	e := newEvent(r, hash, longURL)
+	p.hooks.Redirect(e)
+	p.countHit(hash)
+	p.recordClick(e)
+	if p.interstitial || link.Interstitial {
+		if strings.Contains(r.Header.Get("Accept"), "application/json") {
+			writeJSON(w, http.StatusOK, struct {
+				LongURL string `json:"long_url"`
+			}{LongURL: longURL})
+			return
+		}
+		writeInterstitial(w, longURL, p.interstitialDelay)
+		return
+	}
+	http.Redirect(w, r, longURL, p.redirectRules.Status(longURL, p.redirectStatus))
+}
+
+func (p *RedirectPath) wasRemoved(hash string) bool {
+	t, ok := p.store.(Tombstoner)
+	if !ok {
+		return false
+	}
+	removed, err := t.WasRemoved(hash)
+	return err == nil && removed
+}
+
+// CodesPath lists every code pointing at an exact destination.
+type CodesPath struct {
+	store Store
+}
+
+func (p *CodesPath) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	longURL := r.URL.Query().Get("url")
+	if longURL == "" {
+		writeJSONError(w, http.StatusBadRequest, "url is empty")
+		return
+	}
+	codes, err := p.store.GetByURL(longURL)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("unexpected error: %v", err))
+		return
+	}
+
+	type codesResponse struct {
+		URL   string   `json:"url"`
+		Codes []string `json:"codes"`
+	}
+	resp := codesResponse{URL: longURL, Codes: codes}
+	if resp.Codes == nil {
+		resp.Codes = []string{}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+type AvailablePath struct {
+	store       Store
+	suggestions int
+}
+
+func (p *AvailablePath) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	alias := r.URL.Query().Get("alias")
+	if alias == "" {
+		writeJSONError(w, http.StatusBadRequest, "alias is empty")
 		return
 	}
-	longURL, err := p.store.Get(hash)
+
+	type availableResponse struct {
+		Alias       string   `json:"alias"`
+		Available   bool     `json:"available"`
+		Suggestions []string `json:"suggestions,omitempty"`
+	}
+	free, err := p.isFree(alias)
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		w.Write([]byte("not found"))
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("unexpected error: %v", err))
 		return
 	}
-	http.Redirect(w, r, longURL, http.StatusTemporaryRedirect)
+	resp := availableResponse{Alias: alias, Available: free}
+	if !resp.Available {
+		resp.Suggestions, err = p.suggest(alias)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("unexpected error: %v", err))
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// isFree reports whether POST /add would accept alias: it must be a valid
+// alias that no live link uses.
+func (p *AvailablePath) isFree(alias string) (bool, error) {
+	if !validAlias(alias) {
+		return false, nil
+	}
+	_, err := p.store.Get(alias)
+	switch {
+	case err == nil, errors.Is(err, ErrInvalidAlias):
+		return false, nil
+	case errors.Is(err, ErrNotFound):
+		return true, nil
+	default:
+		return false, err
+	}
 }
 
+// suggest returns up to p.suggestions free variants of alias, trying
+// numbered suffixes first and falling back to random ones.
+func (p *AvailablePath) suggest(alias string) ([]string, error) {
+	const maxAttempts = 100
+	const suffixChars = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+	var free []string
+	seen := make(map[string]bool)
+	for i := 0; i < maxAttempts && len(free) < p.suggestions; i++ {
+		var candidate string
+		if i < p.suggestions*2 {
+			candidate = fmt.Sprintf("%v-%v", alias, i+2)
+		} else {
+			suffix := make([]byte, 4)
+			for j := range suffix {
+				suffix[j] = suffixChars[rand.Intn(len(suffixChars))]
+			}
+			candidate = fmt.Sprintf("%v-%s", alias, suffix)
+		}
+		if seen[candidate] {
+			continue
+		}
+		seen[candidate] = true
+		ok, err := p.isFree(candidate)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			free = append(free, candidate)
+		}
+	}
+	return free, nil
+}
+
+// storeVersion is written to every saved file. Version 1.0 files, which
+// map codes straight to URLs, are still read.
+const storeVersion = "2.0"
+
 // internal store
 type internalStore struct {
-	Version string            `json:"version"`
-	Items   map[string]string `json:"items"`
+	Version string          `json:"version"`
+	Items   map[string]Link `json:"items"`
+	Removed map[string]bool `json:"removed,omitempty"`
+}
+
+func parseStore(raw []byte) (internalStore, error) {
+	var header struct {
+		Version string `json:"version"`
+	}
+	err := json.Unmarshal(raw, &header)
+	if err != nil {
+		return internalStore{}, fmt.Errorf("unable to parse incoming JSON store data. Error: %v", err)
+	}
+	if header.Version != "1.0" {
+		var is internalStore
+		err = json.Unmarshal(raw, &is)
+		if err != nil {
+			return internalStore{}, fmt.Errorf("unable to parse incoming JSON store data. Error: %v", err)
+		}
+		if is.Items == nil {
+			is.Items = make(map[string]Link)
+		}
+		return is, nil
+	}
+
+	var old struct {
+		Items   map[string]string `json:"items"`
+		Removed map[string]bool   `json:"removed"`
+	}
+	err = json.Unmarshal(raw, &old)
+	if err != nil {
+		return internalStore{}, fmt.Errorf("unable to parse incoming JSON store data. Error: %v", err)
+	}
+	is := internalStore{Version: storeVersion, Items: make(map[string]Link, len(old.Items)), Removed: old.Removed}
+	for code, longURL := range old.Items {
+		is.Items[code] = Link{URL: longURL}
+	}
+	return is, nil
 }
 
+// dropExpired removes links that have expired as of now.
+func (is *internalStore) dropExpired(now time.Time) {
+	for code, link := range is.Items {
+		if link.Expired(now) {
+			delete(is.Items, code)
+		}
+	}
+}
+
+// ErrStoreBusy is returned when a store has too many pending writes to
+// accept another one.
+var ErrStoreBusy = errors.New("store is busy, try again later")
+
+// ErrInsufficientStorage is returned while a FileStore is read-only after
+// failing to persist a write, typically because the disk is full.
+var ErrInsufficientStorage = errors.New("store is read-only: unable to persist writes")
+
+// readOnlyRetry is how long a FileStore stays read-only after a failed write
+// before letting another write try again.
+const readOnlyRetry = 30 * time.Second
+
 type FileStore struct {
 	filenane string
+	// writeSlots bounds the number of in-flight mutations; nil means
+	// unbounded.
+	writeSlots chan struct{}
+	// fileMu makes each read-modify-write of the file atomic within the
+	// process: mutations take it exclusively, lookups share it.
+	fileMu sync.RWMutex
+	// newWriter, when set, wraps the temporary file each save writes
+	// through. Tests use it to make writes fail as on a full disk.
+	newWriter func(f *os.File) io.Writer
+
+	mu sync.Mutex
+	// writeErr is the last persistence failure, and readOnlyUntil the time
+	// writes are attempted again.
+	writeErr      error
+	readOnlyUntil time.Time
+
+	// hits holds click counts not yet written to the file. They are
+	// added to the file every hit flush interval and on Close, so
+	// redirects never wait on a rewrite of the file.
+	hitMu sync.Mutex
+	hits  map[string]int64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// WriteError returns the failure that put the store in read-only mode, or
+// nil when writes are accepted.
+func (s *FileStore) WriteError() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Now().After(s.readOnlyUntil) {
+		return nil
+	}
+	return s.writeErr
 }
 
-func (s *FileStore) Add(shortenedURL, longURL string) error {
+// save atomically replaces the store file with is: the data is written to a
+// temporary file which is then renamed over the original, so a failure at
+// any point leaves the previous good file intact. A failure switches the
+// store to read-only for readOnlyRetry.
+func (s *FileStore) save(is internalStore) error {
+	raw, err := json.Marshal(is)
+	if err != nil {
+		return fmt.Errorf("unable to generate JSON representation for file")
+	}
+	err = writeFileAtomic(s.filenane, raw, s.newWriter)
+	if err != nil {
+		s.mu.Lock()
+		s.writeErr = err
+		s.readOnlyUntil = time.Now().Add(readOnlyRetry)
+		s.mu.Unlock()
+		log.Printf("file store: switching to read-only after failed write: %v", err)
+		return fmt.Errorf("%w: %v", ErrInsufficientStorage, err)
+	}
+	s.mu.Lock()
+	s.writeErr = nil
+	s.mu.Unlock()
+	return nil
+}
+
+// writeFileAtomic replaces filename with data through a temporary file, so
+// a failed write leaves the old contents in place. newWriter, when not nil,
+// wraps the temporary file.
+func writeFileAtomic(filename string, data []byte, newWriter func(f *os.File) io.Writer) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	var w io.Writer = tmp
+	if newWriter != nil {
+		w = newWriter(tmp)
+	}
+	_, err = w.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// acquireWrite reserves a write slot without waiting, so callers get quick
+// feedback instead of queueing behind a burst of full-file rewrites.
+func (s *FileStore) acquireWrite() error {
+	if s.writeSlots == nil {
+		return nil
+	}
+	select {
+	case s.writeSlots <- struct{}{}:
+		return nil
+	default:
+		return ErrStoreBusy
+	}
+}
+
+func (s *FileStore) releaseWrite() {
+	if s.writeSlots != nil {
+		<-s.writeSlots
+	}
+}
+
+// load reads and parses the store file. s.fileMu must be held.
+func (s *FileStore) load() (internalStore, error) {
 	raw, err := os.ReadFile(s.filenane)
+	if err != nil {
+		return internalStore{}, err
+	}
+	return parseStore(raw)
+}
+
+func (s *FileStore) Add(shortenedURL string, link Link) error {
+	err := s.WriteError()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInsufficientStorage, err)
+	}
+	err = s.acquireWrite()
 	if err != nil {
 		return err
 	}
-	var is internalStore
-	err = json.Unmarshal(raw, &is)
+	defer s.releaseWrite()
+	s.fileMu.Lock()
+	defer s.fileMu.Unlock()
+
+	is, err := s.load()
 	if err != nil {
-		return fmt.Errorf("unable to parse incoming JSON store data. Error: %v", err)
+		return err
 	}
+	is.dropExpired(time.Now())
 	_, ok := is.Items[shortenedURL]
 	if ok {
-		return fmt.Errorf("shortened URL already exists")
+		return ErrAlreadyExists
 	}
-	is.Items[shortenedURL] = longURL
-	modraw, err := json.Marshal(is)
+	is.Items[shortenedURL] = link
+	delete(is.Removed, shortenedURL)
+	return s.save(is)
+}
+
+// AddBatch adds every record with a single read and write of the file.
+// When the write fails, every record that would have been added reports
+// that failure.
+func (s *FileStore) AddBatch(records []LinkRecord) []error {
+	errs := make([]error, len(records))
+	fail := func(err error) []error {
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = err
+			}
+		}
+		return errs
+	}
+	err := s.WriteError()
 	if err != nil {
-		return fmt.Errorf("unable to generate JSON representation for file")
+		return fail(fmt.Errorf("%w: %v", ErrInsufficientStorage, err))
 	}
+	err = s.acquireWrite()
+	if err != nil {
+		return fail(err)
+	}
+	defer s.releaseWrite()
+	s.fileMu.Lock()
+	defer s.fileMu.Unlock()
 
-	err = os.WriteFile(s.filenane, modraw, 0644)
+	is, err := s.load()
 	if err != nil {
-		return err
+		return fail(err)
 	}
-	return nil
+	is.dropExpired(time.Now())
+	added := 0
+	for i, rec := range records {
+		if _, ok := is.Items[rec.Hash]; ok {
+			errs[i] = ErrAlreadyExists
+			continue
+		}
+		is.Items[rec.Hash] = rec.Link
+		delete(is.Removed, rec.Hash)
+		added++
+	}
+	if added == 0 {
+		return errs
+	}
+	err = s.save(is)
+	if err != nil {
+		return fail(err)
+	}
+	return errs
 }
 
 func (s *FileStore) Remove(shortenedURL string) error {
-	raw, err := os.ReadFile(s.filenane)
+	err := s.WriteError()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInsufficientStorage, err)
+	}
+	err = s.acquireWrite()
 	if err != nil {
 		return err
 	}
-	var is internalStore
-	err = json.Unmarshal(raw, &is)
+	defer s.releaseWrite()
+	s.fileMu.Lock()
+	defer s.fileMu.Unlock()
+
+	is, err := s.load()
 	if err != nil {
-		return fmt.Errorf("unable to parse incoming JSON store data. Error: %v", err)
+		return err
 	}
+	is.dropExpired(time.Now())
 	_, ok := is.Items[shortenedURL]
 	if !ok {
-		return fmt.Errorf("shortened URL does not exist")
+		return ErrNotFound
 	}
 	delete(is.Items, shortenedURL)
-	modraw, err := json.Marshal(is)
+	s.hitMu.Lock()
+	delete(s.hits, shortenedURL)
+	s.hitMu.Unlock()
+	if is.Removed == nil {
+		is.Removed = make(map[string]bool)
+	}
+	is.Removed[shortenedURL] = true
+	return s.save(is)
+}
+
+// Get treats an expired link as missing. It only holds the read lock, so
+// the entry is dropped from the file by the next write instead.
+func (s *FileStore) Get(shortenedURL string) (Link, error) {
+	s.fileMu.RLock()
+	defer s.fileMu.RUnlock()
+	is, err := s.load()
 	if err != nil {
-		return fmt.Errorf("unable to generate JSON representation for file")
+		return Link{}, err
 	}
-	err = os.WriteFile(s.filenane, modraw, 0644)
+	link, ok := is.Items[shortenedURL]
+	if !ok || link.Expired(time.Now()) {
+		return Link{}, ErrNotFound
+	}
+	s.hitMu.Lock()
+	link.Hits += s.hits[shortenedURL]
+	s.hitMu.Unlock()
+	return link, nil
+}
+
+func (s *FileStore) GetByURL(longURL string) ([]string, error) {
+	s.fileMu.RLock()
+	defer s.fileMu.RUnlock()
+	is, err := s.load()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	is.dropExpired(time.Now())
+	var codes []string
+	for code, link := range is.Items {
+		if link.URL == longURL {
+			codes = append(codes, code)
+		}
 	}
+	sort.Strings(codes)
+	return codes, nil
+}
+
+func (s *FileStore) List(limit, offset int) ([]LinkRecord, error) {
+	s.fileMu.RLock()
+	defer s.fileMu.RUnlock()
+	is, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	records := listPage(is.Items, time.Now(), limit, offset)
+	s.hitMu.Lock()
+	for i := range records {
+		records[i].Hits += s.hits[records[i].Hash]
+	}
+	s.hitMu.Unlock()
+	return records, nil
+}
+
+func (s *FileStore) ListTagged(tags []string, all bool, limit, offset int) ([]LinkRecord, error) {
+	s.fileMu.RLock()
+	defer s.fileMu.RUnlock()
+	is, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	records := listPage(tagged(is.Items, tags, all), time.Now(), limit, offset)
+	s.hitMu.Lock()
+	for i := range records {
+		records[i].Hits += s.hits[records[i].Hash]
+	}
+	s.hitMu.Unlock()
+	return records, nil
+}
+
+func (s *FileStore) TagCounts() (map[string]int, error) {
+	s.fileMu.RLock()
+	defer s.fileMu.RUnlock()
+	is, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return countTags(is.Items, time.Now()), nil
+}
+
+func (s *FileStore) WasRemoved(shortenedURL string) (bool, error) {
+	s.fileMu.RLock()
+	defer s.fileMu.RUnlock()
+	is, err := s.load()
+	if err != nil {
+		return false, err
+	}
+	return is.Removed[shortenedURL], nil
+}
+
+// Rewrite applies fn to every entry and saves the result in a single
+// write, so either all changes land or none do.
+func (s *FileStore) Rewrite(fn func(code, longURL string) (string, bool, error), dryRun bool) ([]rewriteChange, error) {
+	err := s.WriteError()
+	if err != nil && !dryRun {
+		return nil, fmt.Errorf("%w: %v", ErrInsufficientStorage, err)
+	}
+	err = s.acquireWrite()
+	if err != nil {
+		return nil, err
+	}
+	defer s.releaseWrite()
+	s.fileMu.Lock()
+	defer s.fileMu.Unlock()
+
+	is, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	is.dropExpired(time.Now())
+	var changes []rewriteChange
+	for code, link := range is.Items {
+		rewritten, ok, err := fn(code, link.URL)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		changes = append(changes, rewriteChange{Code: code, From: link.URL, To: rewritten})
+		link.URL = rewritten
+		is.Items[code] = link
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Code < changes[j].Code })
+	if dryRun || len(changes) == 0 {
+		return changes, nil
+	}
+	return changes, s.save(is)
+}
+
+// Hit counts a redirect in memory; flushHits adds the counts to the file
+// later. Counts for codes that are gone by then are dropped.
+func (s *FileStore) Hit(shortenedURL string) error {
+	s.hitMu.Lock()
+	defer s.hitMu.Unlock()
+	s.hits[shortenedURL]++
 	return nil
 }
 
-func (s *FileStore) Get(shortenedURL string) (string, error) {
-	raw, err := os.ReadFile(s.filenane)
+// flushHits adds the pending click counts to the file in a single write.
+// On failure they stay pending for the next attempt.
+func (s *FileStore) flushHits() error {
+	err := s.WriteError()
 	if err != nil {
-		return "", err
+		return fmt.Errorf("%w: %v", ErrInsufficientStorage, err)
 	}
-	var is internalStore
-	err = json.Unmarshal(raw, &is)
+	err = s.acquireWrite()
 	if err != nil {
-		return "", fmt.Errorf("unable to parse incoming JSON store data. Error: %v", err)
+		return err
 	}
-	longURL, ok := is.Items[shortenedURL]
-	if !ok {
-		return "", fmt.Errorf("shortened URL does not exist")
+	defer s.releaseWrite()
+	s.fileMu.Lock()
+	defer s.fileMu.Unlock()
+
+	s.hitMu.Lock()
+	pending := make(map[string]int64, len(s.hits))
+	for code, n := range s.hits {
+		pending[code] = n
 	}
-	return longURL, nil
+	s.hitMu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	is, err := s.load()
+	if err != nil {
+		return err
+	}
+	is.dropExpired(time.Now())
+	for code, n := range pending {
+		link, ok := is.Items[code]
+		if !ok {
+			continue
+		}
+		link.Hits += n
+		is.Items[code] = link
+	}
+	err = s.save(is)
+	if err != nil {
+		return err
+	}
+	// Redirects may have counted more while the file was written.
+	s.hitMu.Lock()
+	for code, n := range pending {
+		s.hits[code] -= n
+		if s.hits[code] == 0 {
+			delete(s.hits, code)
+		}
+	}
+	s.hitMu.Unlock()
+	return nil
+}
+
+func (s *FileStore) run(hitFlush time.Duration) {
+	defer close(s.done)
+	t := time.NewTicker(hitFlush)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			err := s.flushHits()
+			if err != nil {
+				log.Printf("file store: unable to save click counts: %v", err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Close stops the background flush and saves any pending click counts.
+func (s *FileStore) Close() error {
+	close(s.stop)
+	<-s.done
+	return s.flushHits()
+}
+
+func (s *FileStore) Len() (int, error) {
+	s.fileMu.RLock()
+	defer s.fileMu.RUnlock()
+	is, err := s.load()
+	if err != nil {
+		return 0, err
+	}
+	is.dropExpired(time.Now())
+	return len(is.Items), nil
 }
 
-func NewFileStore(filename string) (FileStore, error) {
-	_, err := os.Stat(filename)
-	if os.IsNotExist(err) {
-		is := internalStore{Version: "1.0", Items: make(map[string]string)}
+// NewFileStore opens (creating if needed) the JSON store at filename.
+// maxPendingWrites limits concurrent mutations; 0 means no limit. Click
+// counts are saved every hitFlush.
+func NewFileStore(filename string, maxPendingWrites int, hitFlush time.Duration) (*FileStore, error) {
+	if hitFlush <= 0 {
+		return nil, fmt.Errorf("hit flush interval must be positive")
+	}
+	info, err := os.Stat(filename)
+	switch {
+	case os.IsNotExist(err):
+		is := internalStore{Version: storeVersion, Items: make(map[string]Link)}
 		raw, err := json.Marshal(is)
 		if err != nil {
-			return FileStore{}, fmt.Errorf("unable to generate JSON representation for file")
+			return nil, fmt.Errorf("unable to generate JSON representation for file")
 		}
 
 		err = os.WriteFile(filename, raw, 0644)
 		if err != nil {
-			return FileStore{}, fmt.Errorf("unable to write to file")
+			return nil, fmt.Errorf("unable to create store file %v: %v", filename, err)
+		}
+	case err != nil:
+		return nil, fmt.Errorf("unable to access store file %v: %v", filename, err)
+	case info.IsDir():
+		return nil, fmt.Errorf("store path %v is a directory, expected a JSON file", filename)
+	case !info.Mode().IsRegular():
+		return nil, fmt.Errorf("store path %v is not a regular file", filename)
+	default:
+		// Catch permission problems and corrupt contents now rather
+		// than on the first request that needs to read or rewrite the
+		// file.
+		f, err := os.OpenFile(filename, os.O_RDWR, 0)
+		if err != nil {
+			return nil, fmt.Errorf("store file %v must be readable and writable: %v", filename, err)
+		}
+		raw, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read store file %v: %v", filename, err)
+		}
+		_, err = parseStore(raw)
+		if err != nil {
+			return nil, fmt.Errorf("store file %v is corrupt, restore it from a backup or move it aside: %v", filename, err)
 		}
+		// Saves replace the file with a temporary one written beside
+		// it, so the directory has to be writable as well.
+		tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp-*")
+		if err != nil {
+			return nil, fmt.Errorf("directory of store file %v must be writable: %v", filename, err)
+		}
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+	fs := &FileStore{
+		filenane: filename,
+		hits:     make(map[string]int64),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if maxPendingWrites > 0 {
+		fs.writeSlots = make(chan struct{}, maxPendingWrites)
 	}
-	return FileStore{filenane: filename}, nil
+	go fs.run(hitFlush)
+	return fs, nil
+}
+
+// envOr returns the environment variable key, or fallback when it is unset
+// or empty. It supplies flag defaults so either can configure the server.
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
 }
 
 func main() {
+	addr := flag.String("addr", envOr("URLSHORT_ADDR", ":8080"), "listen address (env URLSHORT_ADDR)")
+	domain := flag.String("domain", envOr("URLSHORT_DOMAIN", "http://localhost:8080"), "public base URL short links are built on (env URLSHORT_DOMAIN)")
+	storeFile := flag.String("store-file", envOr("URLSHORT_STORE_FILE", "store.json"), "JSON file for -store file (env URLSHORT_STORE_FILE)")
+	storeKind := flag.String("store", "file", "where links are kept: file, sql or s3")
+	sqlDriver := flag.String("sql-driver", "sqlite", "database/sql driver name for -store sql")
+	sqlDSN := flag.String("sql-dsn", "", "data source name for -store sql")
+	s3Bucket := flag.String("s3-bucket", "", "bucket holding the store object for -store s3")
+	s3Key := flag.String("s3-key", "store.json", "key of the store object for -store s3")
+	s3Region := flag.String("s3-region", "", "AWS region of -s3-bucket (default from the AWS environment)")
+	memoryCache := flag.Bool("memory-cache", false, "serve reads from an in-memory tier in front of the file store")
+	writePolicy := flag.String("write-policy", "through", "how writes reach the file store when -memory-cache is set: through or back")
+	memoryMaxEntries := flag.Int("memory-max-entries", 0, "maximum links kept by the in-memory tier (0 = unbounded)")
+	memoryEviction := flag.String("memory-eviction", "lru", "which link the in-memory tier drops when full: lru or oldest")
+	aliasFoldCase := flag.Bool("alias-fold-case", false, "treat codes that differ only in case as the same code")
+	strictAlias := flag.Bool("strict-alias", false, "reject codes with control characters or mixed confusable scripts")
+	httpsOnly := flag.Bool("https-only", false, "reject destinations that are not https")
+	upgradeHTTP := flag.Bool("upgrade-http", false, "rewrite http:// destinations to https:// before storing")
+	hitFlush := flag.Duration("hit-flush", 5*time.Second, "how often click counts are saved to the file store")
+	maxPendingWrites := flag.Int("max-pending-writes", 0, "reject file store writes with 503 once this many are in flight (0 = unlimited)")
+	suggestions := flag.Int("suggestions", 3, "number of free alternatives /available offers for a taken alias")
+	canonicalHost := flag.String("canonical-host", "", "301-redirect requests on any other host to this one, e.g. https://example.com")
+	checkConcurrency := flag.Int("check-concurrency", 8, "maximum destinations probed at once across all /admin/check-links calls")
+	checkTimeout := flag.Duration("check-timeout", 5*time.Second, "timeout for each destination probed by /admin/check-links")
+	codeLength := flag.Int("code-length", 0, "default length of sha1 and base62 codes (0 = 10 for sha1, 7 for base62)")
+	minCodeLength := flag.Int("min-code-length", 6, "shortest code length a request may ask for")
+	maxCodeLength := flag.Int("max-code-length", 20, "longest code length a request may ask for")
+	generatorName := flag.String("generator", "base62", "short code scheme: base62, sha1 (hex) or pronounceable")
+	codeAlphabet := flag.String("code-alphabet", base62Alphabet, "alphabet base62 codes are written in; must be single-case with -alias-fold-case")
+	syllables := flag.Int("syllables", 4, "number of syllables in pronounceable codes")
+	syllablePattern := flag.String("syllable-pattern", "CV", "consonant (C) / vowel (V) layout of each pronounceable syllable")
+	goneForRemoved := flag.Bool("gone-for-removed", false, "answer 410 Gone instead of 404 for deleted codes")
+	queryModeName := flag.String("query-mode", "drop", "what to do with a query string on a short link: drop, append or merge")
+	logEvents := flag.Bool("log-events", false, "log every add, remove and redirect")
+	redirectStatus := flag.Int("redirect-status", http.StatusTemporaryRedirect, "default status code for redirects")
+	var redirectRules RedirectRules
+	flag.Var(&redirectRules, "redirect-rule", "host-pattern=status override for redirects to matching destinations (repeatable)")
+	debugLogBodies := flag.Bool("debug-log-bodies", false, "log the (redacted) request body of failed /add requests")
+	debugLogBodyLimit := flag.Int("debug-log-body-limit", 4096, "maximum bytes of a request body logged by -debug-log-bodies")
+	drainGrace := flag.Duration("drain-grace", 5*time.Second, "how long to keep serving after SIGTERM while /healthz reports not-ready")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "how long to wait for in-flight requests on shutdown")
+	linkRate := flag.Float64("link-rate", 0, "sustained redirects per second allowed for each code (0 = unlimited); links may set their own redirect_rate")
+	addRate := flag.Float64("add-rate", 0, "sustained POST /add and /add/bulk requests per second allowed for each client IP (0 = unlimited)")
+	addBurst := flag.Int("add-burst", 20, "requests a client IP may make in a burst above -add-rate")
+	trustForwarded := flag.Bool("trust-forwarded-for", false, "identify clients by X-Forwarded-For; only set behind a proxy that overwrites it")
+	linkBurst := flag.Int("link-burst", 10, "redirects a code may serve in a burst above -link-rate; links may set their own redirect_burst")
+	allowEncrypted := flag.Bool("allow-encrypted", false, "accept client-encrypted destinations that are decrypted in the browser")
+	auditSyslog := flag.String("audit-syslog", "", "send link events to syslog at udp://host:port, tcp://host:port or unixgram:///path")
+	duplicatePolicy := flag.String("duplicate-policy", "allow", "what to do when a destination already has a code: allow, dedupe or reject")
+	jsonCase := flag.String("json-case", "snake", "key casing of JSON responses: snake or camel")
+	var allowPatterns AllowPatterns
+	flag.Var(&allowPatterns, "allow-pattern", "regular expression the whole destination URL must match to be shortened (repeatable)")
+	clickStream := flag.String("click-stream", "", "append a JSON record per redirect to this file")
+	analyticsFile := flag.String("analytics-file", "", "aggregate click counts per link, day and referrer into this file, apart from the link store")
+	analyticsFlush := flag.Duration("analytics-flush", 10*time.Second, "how often aggregated click counts are saved")
+	analyticsMaxReferrers := flag.Int("analytics-max-referrers", 100, "distinct referrers kept per link before the rest are counted as \"(other)\"")
+	clickStreamMaxBytes := flag.Int64("click-stream-max-bytes", 100<<20, "rotate the click stream file once it grows past this size")
+	kafkaBrokers := flag.String("kafka-brokers", "", "comma-separated Kafka brokers to publish link events to (with -kafka-topic)")
+	kafkaTopic := flag.String("kafka-topic", "", "Kafka topic for link events")
+	kafkaBuffer := flag.Int("kafka-buffer", 10000, "Kafka events buffered while waiting to be published")
+	kafkaOverflow := flag.String("kafka-overflow", "drop", "what to do with a Kafka event when the buffer is full: drop or block")
+	growthThreshold := flag.Float64("growth-threshold", 0, "add a syllable to pronounceable codes once this share (0-1) of recent attempts collide (0 = never)")
+	growthWindow := flag.Int("growth-window", 100, "number of recent code attempts -growth-threshold is measured over")
+	apiKeys := APIKeys{}
+	flag.Var(apiKeys, "api-key", "key=scope1,scope2 granting create, delete, read-stats or admin (repeatable; none = open access)")
+	interstitial := flag.Bool("interstitial", false, "show a page naming the destination before following any link")
+	interstitialDelay := flag.Duration("interstitial-delay", 5*time.Second, "how long the interstitial page waits before redirecting")
+	flag.Parse()
+
+	switch *jsonCase {
+	case "snake":
+	case "camel":
+		camelCaseJSON = true
+	default:
+		log.Fatalf("unknown JSON case %q", *jsonCase)
+	}
+
 	log.Print("Hello world started")
 	r := mux.NewRouter()
-	fs, err := NewFileStore("store.json")
+	readiness := NewReadiness()
+	readiness.Register("store")
+	r.Handle("/healthz", readiness).Methods("GET")
+
+	var store Store
+	switch *storeKind {
+	case "file":
+		fs, err := NewFileStore(*storeFile, *maxPendingWrites, *hitFlush)
+		if err != nil {
+			log.Fatalf("unable to create file store: %v", err)
+		}
+		defer func() {
+			if err := fs.Close(); err != nil {
+				log.Printf("file store: unable to save click counts: %v", err)
+			}
+		}()
+		readiness.Probe("store", func() (bool, string) {
+			err := fs.WriteError()
+			if err != nil {
+				// Redirects still work, so stay in rotation but say
+				// why writes are failing.
+				return true, fmt.Sprintf("read-only: %v", err)
+			}
+			return true, *storeFile + " open"
+		})
+		store = fs
+	case "sql":
+		ss, err := NewSQLStore(*sqlDriver, *sqlDSN)
+		if err != nil {
+			log.Fatalf("unable to create sql store: %v", err)
+		}
+		defer ss.Close()
+		readiness.Probe("store", func() (bool, string) {
+			err := ss.db.Ping()
+			if err != nil {
+				return false, err.Error()
+			}
+			return true, *sqlDriver + " database reachable"
+		})
+		store = ss
+	case "s3":
+		if *s3Bucket == "" {
+			log.Fatal("-store s3 needs -s3-bucket")
+		}
+		s3s, err := NewS3Store(*s3Bucket, *s3Key, *s3Region)
+		if err != nil {
+			log.Fatalf("unable to create s3 store: %v", err)
+		}
+		readiness.Set("store", true, fmt.Sprintf("s3://%v/%v", *s3Bucket, *s3Key))
+		store = s3s
+	default:
+		log.Fatalf("unknown store %q, expected file, sql or s3", *storeKind)
+	}
+	if *memoryCache {
+		policy, err := ParseWritePolicy(*writePolicy)
+		if err != nil {
+			log.Fatal(err)
+		}
+		eviction, err := ParseEvictionPolicy(*memoryEviction)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tiered := NewTieredStore(NewBoundedMemoryStore(*memoryMaxEntries, eviction), store, policy)
+		// Runs after serve has drained in-flight requests, so no new
+		// write-backs can start.
+		defer tiered.Flush()
+		store = tiered
+	}
+	store = NewNormalizingStore(store, AliasNormalizer{foldCase: *aliasFoldCase, strict: *strictAlias})
+	metrics := NewMetrics(store)
+	r.Handle("/admin/metrics.json", apiKeys.Require(scopeReadStats, metrics)).Methods("GET")
+	hooks := Hooks{metrics}
+	if *logEvents {
+		hooks = append(hooks, LogHook{})
+	}
+	if *auditSyslog != "" {
+		h, err := NewSyslogHook(*auditSyslog)
+		if err != nil {
+			log.Fatal(err)
+		}
+		hooks = append(hooks, h)
+	}
+	if *clickStream != "" {
+		h, err := NewClickStreamHook(*clickStream, *clickStreamMaxBytes)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer h.Close()
+		hooks = append(hooks, h)
+	}
+	if *kafkaBrokers != "" && *kafkaTopic != "" {
+		overflow, err := ParseOverflowPolicy(*kafkaOverflow)
+		if err != nil {
+			log.Fatal(err)
+		}
+		h := NewKafkaHook(strings.Split(*kafkaBrokers, ","), *kafkaTopic, *kafkaBuffer, overflow)
+		defer func() {
+			if err := h.Close(); err != nil {
+				log.Printf("kafka: unable to close producer: %v", err)
+			}
+		}()
+		hooks = append(hooks, h)
+	}
+
+	var analytics AnalyticsStore
+	if *analyticsFile != "" {
+		a, err := NewFileAnalyticsStore(*analyticsFile, *analyticsFlush, *analyticsMaxReferrers)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer func() {
+			if err := a.Close(); err != nil {
+				log.Printf("analytics: unable to save %v: %v", *analyticsFile, err)
+			}
+		}()
+		analytics = a
+	}
+
+	if *codeLength == 0 {
+		*codeLength = defaultBase62Length
+		if *generatorName == "sha1" {
+			*codeLength = defaultSHA1Length
+		}
+	}
+	if *minCodeLength < 1 || *minCodeLength > *codeLength || *codeLength > *maxCodeLength {
+		log.Fatalf("code lengths must satisfy 1 <= min (%v) <= default (%v) <= max (%v)", *minCodeLength, *codeLength, *maxCodeLength)
+	}
+	var generator CodeGenerator
+	switch *generatorName {
+	case "base62":
+		bg, err := NewBase62Generator(*codeAlphabet, *codeLength)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *aliasFoldCase && mixedCase(*codeAlphabet) {
+			log.Fatalf("-alias-fold-case would merge distinct codes of the mixed-case alphabet %q; pass a single-case -code-alphabet", *codeAlphabet)
+		}
+		generator = bg
+	case "sha1":
+		generator = SHA1Generator{length: *codeLength}
+	case "pronounceable":
+		pg, err := NewPronounceableGenerator(store, *syllablePattern, *syllables)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *growthThreshold > 0 && *growthWindow > 0 {
+			pg.growth = NewCollisionTracker(*growthThreshold, *growthWindow)
+		}
+		generator = pg
+	default:
+		log.Fatalf("unknown generator %q", *generatorName)
+	}
+	duplicates, err := ParseDuplicatePolicy(*duplicatePolicy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	addPath := &AddPath{
+		domain:         *domain,
+		store:          store,
+		generator:      generator,
+		httpsOnly:      *httpsOnly,
+		upgradeHTTP:    *upgradeHTTP,
+		hooks:          hooks,
+		duplicates:     duplicates,
+		allowPatterns:  allowPatterns,
+		allowEncrypted: *allowEncrypted,
+		minLength:      *minCodeLength,
+		maxLength:      *maxCodeLength,
+	}
+	var addHandler http.Handler = addPath
+	if *debugLogBodies {
+		addHandler = &BodyLogger{next: addPath, limit: *debugLogBodyLimit}
+	}
+	addRoute := apiKeys.Require(scopeCreate, addHandler)
+	bulkRoute := apiKeys.Require(scopeCreate, &BulkAddPath{add: addPath})
+	if *addRate > 0 {
+		// One limiter for both routes, so bulk requests count against
+		// the same budget.
+		clients := NewKeyedLimiter(*addRate, *addBurst, 10*time.Minute)
+		addRoute = &ClientRateLimit{limiter: clients, trustForwarded: *trustForwarded, next: addRoute}
+		bulkRoute = &ClientRateLimit{limiter: clients, trustForwarded: *trustForwarded, next: bulkRoute}
+	}
+	r.Handle("/add", addRoute).Methods("POST")
+	r.Handle("/add/bulk", bulkRoute).Methods("POST")
+	r.Handle("/compute", &ComputePath{add: addPath}).Methods("GET")
+	r.Handle("/admin/check-links", apiKeys.Require(scopeAdmin, NewCheckLinksPath(store, *checkConcurrency, *checkTimeout))).Methods("POST")
+	if apiKeys.Grants(scopeAdmin) {
+		r.Handle("/admin/rewrite", apiKeys.Require(scopeAdmin, &RewritePath{store: store, add: addPath, hooks: hooks})).Methods("POST")
+	}
+	r.Handle("/stats/{hash}", apiKeys.Require(scopeReadStats, &StatsPath{domain: addPath.domain, store: store})).Methods("GET")
+	r.Handle("/links", apiKeys.Require(scopeReadStats, &LinksPath{domain: addPath.domain, store: store})).Methods("GET")
+	r.Handle("/tags", apiKeys.Require(scopeReadStats, &TagsPath{store: store})).Methods("GET")
+	r.Handle("/codes", apiKeys.Require(scopeReadStats, &CodesPath{store: store})).Methods("GET")
+	r.Handle("/available", &AvailablePath{store: store, suggestions: *suggestions}).Methods("GET")
+	r.Handle("/{hash}", apiKeys.Require(scopeDelete, &DeletePath{store: store, hooks: hooks})).Methods("DELETE")
+	if !isRedirectStatus(*redirectStatus) {
+		log.Fatalf("%v is not a redirect status", *redirectStatus)
+	}
+	queryMode, err := ParseQueryMode(*queryModeName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Always set, since links may carry their own rate even when
+	// -link-rate leaves the rest unlimited.
+	linkLimiter := NewKeyedLimiter(*linkRate, *linkBurst, 10*time.Minute)
+	r.Handle("/{hash}", &RedirectPath{
+		store:             store,
+		goneForRemoved:    *goneForRemoved,
+		queryMode:         queryMode,
+		hooks:             hooks,
+		redirectRules:     redirectRules,
+		redirectStatus:    *redirectStatus,
+		interstitial:      *interstitial,
+		interstitialDelay: *interstitialDelay,
+		limiter:           linkLimiter,
+		analytics:         analytics,
+	}).Methods("GET")
+	handler := metrics.Wrap(r)
+	if *canonicalHost != "" {
+		handler, err = NewCanonicalHost(*canonicalHost, handler)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	srv := &http.Server{Addr: *addr, Handler: handler}
+	err = serve(srv, readiness, *drainGrace, *shutdownTimeout)
 	if err != nil {
-		panic("unable to create file store")
+		log.Fatal(err)
 	}
-	r.Handle("/add", &AddPath{domain: "http://localhost:8080", store: &fs}).Methods("POST")
-	r.Handle("/{hash}", &DeletePath{store: &fs}).Methods("DELETE")
-	r.Handle("/{hash}", &RedirectPath{store: &fs}).Methods("GET")
-	http.ListenAndServe(":8080", r)
 }
@@ -0,0 +1,1291 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func newTestAddPath(store Store) *AddPath {
+	generator, err := NewBase62Generator(base62Alphabet, defaultBase62Length)
+	if err != nil {
+		panic(err)
+	}
+	return &AddPath{
+		domain:    "sho.rt",
+		store:     store,
+		generator: generator,
+		minLength: 6,
+		maxLength: 20,
+	}
+}
+
+// downStore fails every operation, standing in for an unreachable store.
+type downStore struct{}
+
+var errDown = errors.New("store is down")
+
+func (downStore) Add(string, Link) error              { return errDown }
+func (downStore) Remove(string) error                 { return errDown }
+func (downStore) Get(string) (Link, error)            { return Link{}, errDown }
+func (downStore) GetByURL(string) ([]string, error)   { return nil, errDown }
+func (downStore) List(int, int) ([]LinkRecord, error) { return nil, errDown }
+
+func newTestFileStore(t *testing.T, maxPendingWrites int) *FileStore {
+	t.Helper()
+	fs, err := NewFileStore(filepath.Join(t.TempDir(), "store.json"), maxPendingWrites, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fs.Close() })
+	return fs
+}
+
+func mustAdd(t *testing.T, s Store, code, longURL string) {
+	t.Helper()
+	err := s.Add(code, Link{URL: longURL})
+	if err != nil {
+		t.Fatalf("adding %v: %v", code, err)
+	}
+}
+
+// do runs one request through h and returns the recorded response.
+func do(h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// doHash is do for handlers that read the {hash} route variable.
+func doHash(h http.Handler, method, hash, body string) *httptest.ResponseRecorder {
+	req := mux.SetURLVars(httptest.NewRequest(method, "/"+hash, strings.NewReader(body)), map[string]string{"hash": hash})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// decode unmarshals a JSON response body into a generic map.
+func decode(t *testing.T, rec *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+	var v map[string]interface{}
+	err := json.Unmarshal(rec.Body.Bytes(), &v)
+	if err != nil {
+		t.Fatalf("unable to decode %q: %v", rec.Body.String(), err)
+	}
+	return v
+}
+
+func TestAddPathHTTPSOnly(t *testing.T) {
+	tests := []struct {
+		name        string
+		httpsOnly   bool
+		upgradeHTTP bool
+		longURL     string
+		wantStatus  int
+		wantLongURL string
+	}{
+		{
+			name:        "http allowed by default",
+			longURL:     "http://example.com/",
+			wantStatus:  http.StatusCreated,
+			wantLongURL: "http://example.com/",
+		},
+		{
+			name:       "http rejected with https-only",
+			httpsOnly:  true,
+			longURL:    "http://example.com/",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "ftp rejected with https-only",
+			httpsOnly:  true,
+			longURL:    "ftp://example.com/file",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:        "https accepted with https-only",
+			httpsOnly:   true,
+			longURL:     "https://example.com/",
+			wantStatus:  http.StatusCreated,
+			wantLongURL: "https://example.com/",
+		},
+		{
+			name:        "http upgraded",
+			upgradeHTTP: true,
+			longURL:     "HTTP://example.com/path",
+			wantStatus:  http.StatusCreated,
+			wantLongURL: "https://example.com/path",
+		},
+		{
+			name:        "upgrade satisfies https-only",
+			httpsOnly:   true,
+			upgradeHTTP: true,
+			longURL:     "http://example.com/",
+			wantStatus:  http.StatusCreated,
+			wantLongURL: "https://example.com/",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAddPath(NewMemoryStore())
+			a.httpsOnly = tt.httpsOnly
+			a.upgradeHTTP = tt.upgradeHTTP
+			body, _ := json.Marshal(map[string]string{"url": tt.longURL})
+			rec := do(a, "POST", "/add", string(body))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %v, want %v: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantLongURL == "" {
+				return
+			}
+			if got := decode(t, rec)["long_url"]; got != tt.wantLongURL {
+				t.Errorf("long_url = %v, want %v", got, tt.wantLongURL)
+			}
+		})
+	}
+}
+
+func TestFileStorePendingWrites(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxPending int
+		// busy is how many write slots are taken before the request.
+		busy       int
+		wantStatus int
+	}{
+		{name: "unlimited", maxPending: 0, wantStatus: http.StatusCreated},
+		{name: "free slot", maxPending: 2, busy: 1, wantStatus: http.StatusCreated},
+		{name: "saturated", maxPending: 2, busy: 2, wantStatus: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := newTestFileStore(t, tt.maxPending)
+			for i := 0; i < tt.busy; i++ {
+				if err := fs.acquireWrite(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			start := time.Now()
+			rec := do(newTestAddPath(fs), "POST", "/add", `{"url":"https://example.com/"}`)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %v, want %v: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("request took %v, want a fast failure", elapsed)
+			}
+			for i := 0; i < tt.busy; i++ {
+				fs.releaseWrite()
+			}
+		})
+	}
+}
+
+func TestAvailableSuggestions(t *testing.T) {
+	tests := []struct {
+		name          string
+		taken         []string
+		alias         string
+		suggestions   int
+		wantAvailable bool
+	}{
+		{name: "free alias", alias: "promo", suggestions: 3, wantAvailable: true},
+		{name: "taken alias", taken: []string{"promo"}, alias: "promo", suggestions: 3},
+		{name: "numbered variants taken too", taken: []string{"promo", "promo-2", "promo-3"}, alias: "promo", suggestions: 3},
+		{name: "many suggestions", taken: []string{"promo"}, alias: "promo", suggestions: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			for _, code := range tt.taken {
+				store.Add(code, Link{URL: "https://example.com/" + code})
+			}
+			p := &AvailablePath{store: store, suggestions: tt.suggestions}
+			rec := do(p, "GET", "/available?alias="+tt.alias, "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %v: %s", rec.Code, rec.Body)
+			}
+			var resp struct {
+				Available   bool     `json:"available"`
+				Suggestions []string `json:"suggestions"`
+			}
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if resp.Available != tt.wantAvailable {
+				t.Fatalf("available = %v, want %v", resp.Available, tt.wantAvailable)
+			}
+			if tt.wantAvailable {
+				if len(resp.Suggestions) != 0 {
+					t.Errorf("got suggestions %v for a free alias", resp.Suggestions)
+				}
+				return
+			}
+			if len(resp.Suggestions) != tt.suggestions {
+				t.Errorf("got %v suggestions, want %v", len(resp.Suggestions), tt.suggestions)
+			}
+			seen := make(map[string]bool)
+			for _, s := range resp.Suggestions {
+				if seen[s] {
+					t.Errorf("suggestion %q repeated", s)
+				}
+				seen[s] = true
+				if _, err := store.Get(s); err == nil {
+					t.Errorf("suggestion %q is taken", s)
+				}
+				if !validAlias(s) {
+					t.Errorf("suggestion %q is not a valid alias", s)
+				}
+			}
+		})
+	}
+}
+
+func TestNewFileStorePath(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup prepares dir and returns the store path to open.
+		setup   func(t *testing.T, dir string) string
+		wantErr string
+	}{
+		{
+			name:  "new file is created",
+			setup: func(t *testing.T, dir string) string { return filepath.Join(dir, "store.json") },
+		},
+		{
+			name: "existing store opens",
+			setup: func(t *testing.T, dir string) string {
+				path := filepath.Join(dir, "store.json")
+				os.WriteFile(path, []byte(`{"version":"2.0","items":{}}`), 0644)
+				return path
+			},
+		},
+		{
+			name:    "directory",
+			setup:   func(t *testing.T, dir string) string { return dir },
+			wantErr: "is a directory",
+		},
+		{
+			name:    "missing parent directory",
+			setup:   func(t *testing.T, dir string) string { return filepath.Join(dir, "missing", "store.json") },
+			wantErr: "unable to create store file",
+		},
+		{
+			name:    "not a regular file",
+			setup:   func(t *testing.T, dir string) string { return os.DevNull },
+			wantErr: "not a regular file",
+		},
+		{
+			name: "unwritable file",
+			setup: func(t *testing.T, dir string) string {
+				if os.Geteuid() == 0 {
+					t.Skip("root can write read-only files")
+				}
+				path := filepath.Join(dir, "store.json")
+				os.WriteFile(path, []byte(`{"version":"2.0","items":{}}`), 0444)
+				return path
+			},
+			wantErr: "must be readable and writable",
+		},
+		{
+			name: "unwritable directory",
+			setup: func(t *testing.T, dir string) string {
+				if os.Geteuid() == 0 {
+					t.Skip("root can write read-only directories")
+				}
+				path := filepath.Join(dir, "store.json")
+				os.WriteFile(path, []byte(`{"version":"2.0","items":{}}`), 0644)
+				os.Chmod(dir, 0555)
+				t.Cleanup(func() { os.Chmod(dir, 0755) })
+				return path
+			},
+			wantErr: "must be writable",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := tt.setup(t, t.TempDir())
+			fs, err := NewFileStore(path, 0, time.Hour)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				fs.Close()
+				return
+			}
+			if err == nil {
+				fs.Close()
+				t.Fatalf("got no error, want one containing %q", tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %q, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRedirectGoneForRemoved(t *testing.T) {
+	tests := []struct {
+		name           string
+		goneForRemoved bool
+		hash           string
+		wantStatus     int
+	}{
+		{name: "removed code is 404 by default", hash: "removed", wantStatus: http.StatusNotFound},
+		{name: "removed code is 410 when enabled", goneForRemoved: true, hash: "removed", wantStatus: http.StatusGone},
+		{name: "unknown code stays 404", goneForRemoved: true, hash: "never", wantStatus: http.StatusNotFound},
+		{name: "live code redirects", goneForRemoved: true, hash: "live", wantStatus: http.StatusTemporaryRedirect},
+	}
+	stores := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store { return NewMemoryStore() },
+		"file":   func(t *testing.T) Store { return newTestFileStore(t, 0) },
+	}
+	for storeName, newStore := range stores {
+		for _, tt := range tests {
+			t.Run(storeName+"/"+tt.name, func(t *testing.T) {
+				store := newStore(t)
+				mustAdd(t, store, "live", "https://example.com/live")
+				mustAdd(t, store, "removed", "https://example.com/removed")
+				if err := store.Remove("removed"); err != nil {
+					t.Fatal(err)
+				}
+				p := &RedirectPath{
+					store:          store,
+					goneForRemoved: tt.goneForRemoved,
+					redirectStatus: http.StatusTemporaryRedirect,
+				}
+				rec := doHash(p, "GET", tt.hash, "")
+				if rec.Code != tt.wantStatus {
+					t.Errorf("status = %v, want %v", rec.Code, tt.wantStatus)
+				}
+			})
+		}
+	}
+}
+
+func TestAddPathLengthOverride(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantLength int
+	}{
+		{name: "default length", body: `{"url":"https://example.com/"}`, wantStatus: http.StatusCreated, wantLength: defaultBase62Length},
+		{name: "minimum", body: `{"url":"https://example.com/","length":6}`, wantStatus: http.StatusCreated, wantLength: 6},
+		{name: "longer", body: `{"url":"https://example.com/","length":12}`, wantStatus: http.StatusCreated, wantLength: 12},
+		{name: "maximum", body: `{"url":"https://example.com/","length":20}`, wantStatus: http.StatusCreated, wantLength: 20},
+		{name: "below minimum", body: `{"url":"https://example.com/","length":5}`, wantStatus: http.StatusBadRequest},
+		{name: "above maximum", body: `{"url":"https://example.com/","length":21}`, wantStatus: http.StatusBadRequest},
+		{name: "negative", body: `{"url":"https://example.com/","length":-1}`, wantStatus: http.StatusBadRequest},
+		{name: "with alias", body: `{"url":"https://example.com/","alias":"mine","length":8}`, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(newTestAddPath(NewMemoryStore()), "POST", "/add", tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %v, want %v: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantLength == 0 {
+				return
+			}
+			shortened, _ := decode(t, rec)["shortened_url"].(string)
+			code := strings.TrimPrefix(shortened, "https://sho.rt/")
+			if len(code) != tt.wantLength {
+				t.Errorf("code %q has length %v, want %v", code, len(code), tt.wantLength)
+			}
+		})
+	}
+}
+
+func TestAddPathLengthOverrideUnsupported(t *testing.T) {
+	a := newTestAddPath(NewMemoryStore())
+	g, err := NewPronounceableGenerator(a.store, "CV", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.generator = g
+	rec := do(a, "POST", "/add", `{"url":"https://example.com/","length":8}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %v, want %v", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestBoundedMemoryStore(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy EvictionPolicy
+		// read codes after adding a, b and c and before adding d.
+		read        []string
+		wantEvicted string
+	}{
+		{name: "oldest drops the first added", policy: EvictOldest, wantEvicted: "a"},
+		{name: "oldest ignores reads", policy: EvictOldest, read: []string{"a"}, wantEvicted: "a"},
+		{name: "lru drops the first added when unread", policy: EvictLRU, wantEvicted: "a"},
+		{name: "lru keeps recently read", policy: EvictLRU, read: []string{"a"}, wantEvicted: "b"},
+		{name: "lru follows read order", policy: EvictLRU, read: []string{"a", "b"}, wantEvicted: "c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewBoundedMemoryStore(3, tt.policy)
+			for _, code := range []string{"a", "b", "c"} {
+				mustAdd(t, m, code, "https://example.com/"+code)
+			}
+			for _, code := range tt.read {
+				m.Get(code)
+			}
+			mustAdd(t, m, "d", "https://example.com/d")
+
+			if n, _ := m.Len(); n != 3 {
+				t.Errorf("len = %v, want 3", n)
+			}
+			for _, code := range []string{"a", "b", "c", "d"} {
+				_, err := m.Get(code)
+				if evicted := err != nil; evicted != (code == tt.wantEvicted) {
+					t.Errorf("%v evicted = %v, want %v", code, evicted, code == tt.wantEvicted)
+				}
+			}
+		})
+	}
+}
+
+func TestUnboundedMemoryStore(t *testing.T) {
+	m := NewMemoryStore()
+	for i := 0; i < 1000; i++ {
+		mustAdd(t, m, strconv.Itoa(i), "https://example.com/")
+	}
+	if n, _ := m.Len(); n != 1000 {
+		t.Errorf("len = %v, want 1000", n)
+	}
+}
+
+func TestBoundedMemoryStoreTombstones(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxEntries  int
+		removed     int
+		wantRemoved int
+	}{
+		{name: "under the limit", maxEntries: 3, removed: 2, wantRemoved: 2},
+		{name: "capped at the limit", maxEntries: 3, removed: 10, wantRemoved: 3},
+		{name: "unbounded", maxEntries: 0, removed: 10, wantRemoved: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewBoundedMemoryStore(tt.maxEntries, EvictOldest)
+			for i := 0; i < tt.removed; i++ {
+				code := strconv.Itoa(i)
+				mustAdd(t, m, code, "https://example.com/")
+				if err := m.Remove(code); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got := 0
+			for i := 0; i < tt.removed; i++ {
+				if removed, _ := m.WasRemoved(strconv.Itoa(i)); removed {
+					got++
+				}
+			}
+			if got != tt.wantRemoved {
+				t.Errorf("%v tombstones kept, want %v", got, tt.wantRemoved)
+			}
+			// The most recent removal is always remembered.
+			if removed, _ := m.WasRemoved(strconv.Itoa(tt.removed - 1)); !removed {
+				t.Errorf("latest removal forgotten")
+			}
+		})
+	}
+}
+
+func TestLinkJSON(t *testing.T) {
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name string
+		link Link
+		want string
+	}{
+		{name: "never expires", link: Link{URL: "https://example.com/"}, want: `{"url":"https://example.com/"}`},
+		{name: "expires", link: Link{URL: "https://example.com/", ExpiresAt: expires}, want: `{"url":"https://example.com/","expires_at":"2030-01-02T03:04:05Z"}`},
+		{name: "hits", link: Link{URL: "https://example.com/", Hits: 3}, want: `{"url":"https://example.com/","hits":3}`},
+		{name: "tags", link: Link{URL: "https://example.com/", Tags: []string{"a", "b"}}, want: `{"url":"https://example.com/","tags":["a","b"]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := json.Marshal(tt.link)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(raw) != tt.want {
+				t.Errorf("got %s, want %s", raw, tt.want)
+			}
+			var back Link
+			if err := json.Unmarshal(raw, &back); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(back, tt.link) {
+				t.Errorf("round trip gave %+v, want %+v", back, tt.link)
+			}
+		})
+	}
+}
+
+func TestComputeMatchesAdd(t *testing.T) {
+	tests := []struct {
+		name      string
+		generator CodeGenerator
+		longURL   string
+		length    int
+	}{
+		{name: "base62", longURL: "https://example.com/a"},
+		{name: "base62 with length", longURL: "https://example.com/a", length: 12},
+		{name: "base62 with query", longURL: "https://example.com/?q=a b&x=1"},
+		{name: "sha1", generator: SHA1Generator{length: defaultSHA1Length}, longURL: "https://example.com/a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			a := newTestAddPath(store)
+			if tt.generator != nil {
+				a.generator = tt.generator
+			}
+			query := url.Values{"url": {tt.longURL}}
+			if tt.length != 0 {
+				query.Set("length", strconv.Itoa(tt.length))
+			}
+			rec := do(&ComputePath{add: a}, "GET", "/compute?"+query.Encode(), "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("compute status = %v: %s", rec.Code, rec.Body)
+			}
+			computed := decode(t, rec)
+			if n, _ := store.Len(); n != 0 {
+				t.Errorf("compute stored %v links", n)
+			}
+
+			body, _ := json.Marshal(map[string]interface{}{"url": tt.longURL, "length": tt.length})
+			rec = do(a, "POST", "/add", string(body))
+			if rec.Code != http.StatusCreated {
+				t.Fatalf("add status = %v: %s", rec.Code, rec.Body)
+			}
+			added := decode(t, rec)
+			if computed["shortened_url"] != added["shortened_url"] {
+				t.Errorf("compute gave %v, add gave %v", computed["shortened_url"], added["shortened_url"])
+			}
+		})
+	}
+}
+
+func TestComputeRejectsRandomGenerators(t *testing.T) {
+	a := newTestAddPath(NewMemoryStore())
+	g, err := NewPronounceableGenerator(a.store, "CV", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.generator = g
+	rec := do(&ComputePath{add: a}, "GET", "/compute?url=https://example.com/", "")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %v, want %v", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestShortURL(t *testing.T) {
+	tests := []struct {
+		domain string
+		want   string
+	}{
+		{domain: "sho.rt", want: "https://sho.rt/abc"},
+		{domain: "sho.rt/", want: "https://sho.rt/abc"},
+		{domain: "localhost:8080", want: "https://localhost:8080/abc"},
+		{domain: "localhost:8080/", want: "https://localhost:8080/abc"},
+		{domain: "http://localhost:8080", want: "http://localhost:8080/abc"},
+		{domain: "http://localhost:8080//", want: "http://localhost:8080/abc"},
+		{domain: "https://example.com/s", want: "https://example.com/s/abc"},
+		{domain: "https://example.com/s/", want: "https://example.com/s/abc"},
+		{domain: "example.com/links/go", want: "https://example.com/links/go/abc"},
+		{domain: "https://example.com/s?x=1#frag", want: "https://example.com/s/abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			if got := shortURL(tt.domain, "abc"); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			a := newTestAddPath(NewMemoryStore())
+			a.domain = tt.domain
+			rec := do(a, "POST", "/add", `{"url": "https://example.com/", "alias": "abc"}`)
+			if rec.Code != http.StatusCreated {
+				t.Fatalf("status = %v: %s", rec.Code, rec.Body)
+			}
+			if got := decode(t, rec)["shortened_url"]; got != tt.want {
+				t.Errorf("response url = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddPathDuplicatePolicy(t *testing.T) {
+	const first = `{"url": "https://example.com/"}`
+	tests := []struct {
+		name   string
+		policy DuplicatePolicy
+		// random swaps in a generator that gives every request a new code.
+		random     bool
+		second     string
+		wantStatus int
+		wantSame   bool
+		wantCodes  int
+	}{
+		{name: "allow", policy: DuplicatesAllow, random: true, second: first, wantStatus: http.StatusCreated, wantCodes: 2},
+		{name: "allow deterministic", policy: DuplicatesAllow, second: first, wantStatus: http.StatusOK, wantSame: true, wantCodes: 1},
+		{name: "dedupe", policy: DuplicatesDedupe, random: true, second: first, wantStatus: http.StatusOK, wantSame: true, wantCodes: 1},
+		{name: "dedupe with alias", policy: DuplicatesDedupe, second: `{"url": "https://example.com/", "alias": "mine"}`, wantStatus: http.StatusCreated, wantCodes: 2},
+		{name: "dedupe with ttl", policy: DuplicatesDedupe, second: `{"url": "https://example.com/", "ttl_seconds": 60}`, wantStatus: http.StatusCreated, wantCodes: 2},
+		{name: "dedupe other destination", policy: DuplicatesDedupe, second: `{"url": "https://example.org/"}`, wantStatus: http.StatusCreated, wantCodes: 1},
+		{name: "reject", policy: DuplicatesReject, second: first, wantStatus: http.StatusConflict, wantCodes: 1},
+		{name: "reject with alias", policy: DuplicatesReject, second: `{"url": "https://example.com/", "alias": "mine"}`, wantStatus: http.StatusConflict, wantCodes: 1},
+		{name: "reject other destination", policy: DuplicatesReject, second: `{"url": "https://example.org/"}`, wantStatus: http.StatusCreated, wantCodes: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			a := newTestAddPath(store)
+			a.duplicates = tt.policy
+			if tt.random {
+				g, err := NewPronounceableGenerator(store, "CVC", 4)
+				if err != nil {
+					t.Fatal(err)
+				}
+				a.generator = g
+			}
+			rec := do(a, "POST", "/add", first)
+			if rec.Code != http.StatusCreated {
+				t.Fatalf("first add: status = %v: %s", rec.Code, rec.Body)
+			}
+			firstURL := decode(t, rec)["shortened_url"]
+
+			rec = do(a, "POST", "/add", tt.second)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("second add: status = %v, want %v: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rec.Code < 300 {
+				if same := decode(t, rec)["shortened_url"] == firstURL; same != tt.wantSame {
+					t.Errorf("same code = %v, want %v", same, tt.wantSame)
+				}
+			}
+			codes, err := store.GetByURL("https://example.com/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(codes) != tt.wantCodes {
+				t.Errorf("destination has %v codes, want %v", len(codes), tt.wantCodes)
+			}
+		})
+	}
+}
+
+func TestParseDuplicatePolicy(t *testing.T) {
+	for s, want := range map[string]DuplicatePolicy{"allow": DuplicatesAllow, "dedupe": DuplicatesDedupe, "reject": DuplicatesReject} {
+		got, err := ParseDuplicatePolicy(s)
+		if err != nil || got != want {
+			t.Errorf("%v: got %v, %v", s, got, err)
+		}
+	}
+	if _, err := ParseDuplicatePolicy("share"); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
+
+func TestCodesPath(t *testing.T) {
+	stores := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store { return NewMemoryStore() },
+		"file":   func(t *testing.T) Store { return newTestFileStore(t, 0) },
+		"sql":    func(t *testing.T) Store { return newTestSQLStore(t) },
+	}
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+		wantCodes  string
+	}{
+		{name: "zero", target: "/codes?url=https://example.net/", wantStatus: http.StatusOK, wantCodes: ""},
+		{name: "one", target: "/codes?url=https://example.org/", wantStatus: http.StatusOK, wantCodes: "ccc"},
+		{name: "multiple", target: "/codes?url=https://example.com/", wantStatus: http.StatusOK, wantCodes: "aaa,bbb"},
+		{name: "expired excluded", target: "/codes?url=https://example.com/old", wantStatus: http.StatusOK, wantCodes: ""},
+		{name: "exact match only", target: "/codes?url=https://example.com", wantStatus: http.StatusOK, wantCodes: ""},
+		{name: "missing url", target: "/codes", wantStatus: http.StatusBadRequest},
+	}
+	for storeName, newStore := range stores {
+		for _, tt := range tests {
+			t.Run(storeName+"/"+tt.name, func(t *testing.T) {
+				store := newStore(t)
+				mustAdd(t, store, "bbb", "https://example.com/")
+				mustAdd(t, store, "aaa", "https://example.com/")
+				mustAdd(t, store, "ccc", "https://example.org/")
+				store.Add("ddd", Link{URL: "https://example.com/old", ExpiresAt: time.Now().Add(-time.Second)})
+
+				rec := do(&CodesPath{store: store}, "GET", tt.target, "")
+				if rec.Code != tt.wantStatus {
+					t.Fatalf("status = %v, want %v: %s", rec.Code, tt.wantStatus, rec.Body)
+				}
+				if tt.wantStatus != http.StatusOK {
+					return
+				}
+				var resp struct {
+					Codes []string `json:"codes"`
+				}
+				if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+					t.Fatal(err)
+				}
+				if resp.Codes == nil {
+					t.Error("codes is null, want an array")
+				}
+				if got := strings.Join(resp.Codes, ","); got != tt.wantCodes {
+					t.Errorf("codes = %q, want %q", got, tt.wantCodes)
+				}
+			})
+		}
+	}
+}
+
+// fullDiskWriter accepts left more bytes and then fails with ENOSPC.
+type fullDiskWriter struct {
+	w    io.Writer
+	left int
+}
+
+func (d *fullDiskWriter) Write(p []byte) (int, error) {
+	if len(p) <= d.left {
+		d.left -= len(p)
+		return d.w.Write(p)
+	}
+	n, _ := d.w.Write(p[:d.left])
+	d.left = 0
+	return n, syscall.ENOSPC
+}
+
+// fillDisk makes the store's saves fail with ENOSPC after writing n bytes,
+// as they would on a full disk. It returns a func freeing the space.
+func fillDisk(s *FileStore, n int) func() {
+	s.fileMu.Lock()
+	s.newWriter = func(f *os.File) io.Writer { return &fullDiskWriter{w: f, left: n} }
+	s.fileMu.Unlock()
+	return func() {
+		s.fileMu.Lock()
+		s.newWriter = nil
+		s.fileMu.Unlock()
+	}
+}
+
+func TestFileStoreWriteFailure(t *testing.T) {
+	store := newTestFileStore(t, 0)
+	mustAdd(t, store, "abc", "https://example.com/")
+	before, err := os.ReadFile(store.filenane)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	free := fillDisk(store, len(before)/2)
+	a := newTestAddPath(store)
+	rec := do(a, "POST", "/add", `{"url": "https://example.org/"}`)
+	free()
+	if rec.Code != http.StatusInsufficientStorage {
+		t.Fatalf("add status = %v, want %v: %s", rec.Code, http.StatusInsufficientStorage, rec.Body)
+	}
+	if store.WriteError() == nil {
+		t.Fatal("store did not switch to read-only")
+	}
+
+	after, err := os.ReadFile(store.filenane)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Errorf("store file changed by a failed write:\n%s", after)
+	}
+	if tmp, _ := filepath.Glob(store.filenane + ".tmp-*"); len(tmp) > 0 {
+		t.Errorf("temporary files left behind: %v", tmp)
+	}
+
+	// While read-only, every write fails fast and reads keep working.
+	tests := []struct {
+		name       string
+		h          http.Handler
+		method     string
+		hash       string
+		body       string
+		wantStatus int
+	}{
+		{name: "redirect", h: &RedirectPath{store: store, redirectStatus: http.StatusFound}, method: "GET", hash: "abc", wantStatus: http.StatusFound},
+		{name: "stats", h: &StatsPath{store: store}, method: "GET", hash: "abc", wantStatus: http.StatusOK},
+		{name: "delete", h: &DeletePath{store: store}, method: "DELETE", hash: "abc", wantStatus: http.StatusInsufficientStorage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := doHash(tt.h, tt.method, tt.hash, tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %v, want %v: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+	rec = do(a, "POST", "/add", `{"url": "https://example.org/"}`)
+	if rec.Code != http.StatusInsufficientStorage {
+		t.Errorf("add while read-only: status = %v, want %v", rec.Code, http.StatusInsufficientStorage)
+	}
+	if _, err := store.Get("abc"); err != nil {
+		t.Errorf("get while read-only: %v", err)
+	}
+
+	// Once the retry interval has passed, writes are attempted again.
+	store.mu.Lock()
+	store.readOnlyUntil = time.Now().Add(-time.Second)
+	store.mu.Unlock()
+	err = store.Add("def", Link{URL: "https://example.org/"})
+	if err != nil {
+		t.Fatalf("add after recovery: %v", err)
+	}
+	if store.WriteError() != nil {
+		t.Errorf("store still read-only after a successful write: %v", store.WriteError())
+	}
+}
+
+func TestFileStoreWriteFailureBatch(t *testing.T) {
+	store := newTestFileStore(t, 0)
+	mustAdd(t, store, "abc", "https://example.com/")
+
+	free := fillDisk(store, 0)
+	errs := store.AddBatch([]LinkRecord{
+		{Hash: "abc", Link: Link{URL: "https://example.com/"}},
+		{Hash: "def", Link: Link{URL: "https://example.org/"}},
+	})
+	free()
+	for i, err := range errs {
+		if !errors.Is(err, ErrInsufficientStorage) && !errors.Is(err, ErrAlreadyExists) {
+			t.Errorf("record %v: error = %v", i, err)
+		}
+	}
+	if !errors.Is(errs[1], ErrInsufficientStorage) {
+		t.Errorf("new record: error = %v, want %v", errs[1], ErrInsufficientStorage)
+	}
+	if _, err := store.Get("def"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("get def: error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestAddPathAlias(t *testing.T) {
+	tests := []struct {
+		name       string
+		alias      string
+		wantStatus int
+	}{
+		{name: "letters", alias: "docs", wantStatus: http.StatusCreated},
+		{name: "dash and underscore", alias: "Spring_Promo-2024", wantStatus: http.StatusCreated},
+		{name: "taken", alias: "taken", wantStatus: http.StatusConflict},
+		{name: "slash", alias: "a/b", wantStatus: http.StatusBadRequest},
+		{name: "space", alias: "a b", wantStatus: http.StatusBadRequest},
+		{name: "dot", alias: "a.b", wantStatus: http.StatusBadRequest},
+		{name: "non-ascii letters", alias: "café", wantStatus: http.StatusCreated},
+		{name: "other scripts", alias: "промо-2024", wantStatus: http.StatusCreated},
+		{name: "symbol", alias: "a☃b", wantStatus: http.StatusBadRequest},
+		{name: "reserved", alias: "add", wantStatus: http.StatusBadRequest},
+		{name: "reserved in another case", alias: "Admin", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			mustAdd(t, store, "taken", "https://example.org/")
+			body, _ := json.Marshal(map[string]string{"url": "https://example.com/", "alias": tt.alias})
+			rec := do(newTestAddPath(store), "POST", "/add", string(body))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %v, want %v: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			link, err := store.Get(tt.alias)
+			switch {
+			case tt.wantStatus == http.StatusCreated:
+				if err != nil || link.URL != "https://example.com/" {
+					t.Errorf("alias stores %q, %v", link.URL, err)
+				}
+				if got := decode(t, rec)["shortened_url"]; got != "https://sho.rt/"+url.PathEscape(tt.alias) {
+					t.Errorf("shortened_url = %v", got)
+				}
+			case tt.alias == "taken":
+				if link.URL != "https://example.org/" {
+					t.Errorf("conflict overwrote the existing link with %q", link.URL)
+				}
+			}
+		})
+	}
+}
+
+func TestAvailableRejectsUnusableAliases(t *testing.T) {
+	tests := []struct {
+		name          string
+		store         Store
+		alias         string
+		wantStatus    int
+		wantAvailable bool
+	}{
+		{name: "free", store: NewMemoryStore(), alias: "promo", wantStatus: http.StatusOK, wantAvailable: true},
+		{name: "reserved", store: NewMemoryStore(), alias: "admin", wantStatus: http.StatusOK},
+		{name: "invalid characters", store: NewMemoryStore(), alias: "a/b", wantStatus: http.StatusOK},
+		{name: "empty", store: NewMemoryStore(), alias: "", wantStatus: http.StatusBadRequest},
+		{name: "store failure", store: downStore{}, alias: "promo", wantStatus: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &AvailablePath{store: tt.store, suggestions: 3}
+			rec := do(p, "GET", "/available?alias="+url.QueryEscape(tt.alias), "")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %v, want %v: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			resp := decode(t, rec)
+			if resp["available"] != tt.wantAvailable {
+				t.Errorf("available = %v, want %v", resp["available"], tt.wantAvailable)
+			}
+			suggestions, _ := resp["suggestions"].([]interface{})
+			for _, s := range suggestions {
+				if !validAlias(s.(string)) {
+					t.Errorf("suggested unusable alias %q", s)
+				}
+			}
+		})
+	}
+}
+
+func TestAddPathTruncationCollision(t *testing.T) {
+	const longURL = "https://example.com/"
+	g := SHA1Generator{length: defaultSHA1Length}
+	code := func(length int) string {
+		c, err := g.GenerateLength(longURL, length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	codes := func(from, to int) []string {
+		var cs []string
+		for n := from; n <= to; n++ {
+			cs = append(cs, code(n))
+		}
+		return cs
+	}
+	tests := []struct {
+		name string
+		// taken maps codes to other destinations before the add.
+		taken      []string
+		body       string
+		repeat     bool
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "free", body: `{"url": "https://example.com/"}`, wantStatus: http.StatusCreated, wantCode: code(10)},
+		{name: "same url twice", body: `{"url": "https://example.com/"}`, repeat: true, wantStatus: http.StatusOK, wantCode: code(10)},
+		{name: "collision extends", taken: []string{code(10)}, body: `{"url": "https://example.com/"}`, wantStatus: http.StatusCreated, wantCode: code(11)},
+		{name: "repeated collisions", taken: codes(10, 12), body: `{"url": "https://example.com/"}`, wantStatus: http.StatusCreated, wantCode: code(13)},
+		{name: "collision then same url", taken: []string{code(10)}, body: `{"url": "https://example.com/"}`, repeat: true, wantStatus: http.StatusOK, wantCode: code(11)},
+		{name: "expiring link is not reused", body: `{"url": "https://example.com/", "ttl_seconds": 60}`, repeat: true, wantStatus: http.StatusCreated, wantCode: code(11)},
+		{name: "every length taken", taken: codes(10, 40), body: `{"url": "https://example.com/"}`, wantStatus: http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			for i, c := range tt.taken {
+				mustAdd(t, store, c, "https://example.org/"+strconv.Itoa(i))
+			}
+			a := newTestAddPath(store)
+			a.generator = g
+			rec := do(a, "POST", "/add", tt.body)
+			if tt.repeat {
+				rec = do(a, "POST", "/add", tt.body)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %v, want %v: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantCode == "" {
+				return
+			}
+			if got := decode(t, rec)["shortened_url"]; got != "https://sho.rt/"+tt.wantCode {
+				t.Errorf("shortened_url = %v, want code %v", got, tt.wantCode)
+			}
+			for i, c := range tt.taken {
+				link, _ := store.Get(c)
+				if link.URL != "https://example.org/"+strconv.Itoa(i) {
+					t.Errorf("%v was overwritten with %q", c, link.URL)
+				}
+			}
+		})
+	}
+}
+
+func TestFileStoreConcurrentWrites(t *testing.T) {
+	const n = 50
+	tests := []struct {
+		name string
+		// removes also deletes this many seeded links concurrently.
+		removes int
+	}{
+		{name: "adds"},
+		{name: "adds with removes and reads", removes: 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := newTestFileStore(t, 0)
+			for i := 0; i < tt.removes; i++ {
+				mustAdd(t, fs, fmt.Sprintf("old%v", i), "https://example.org/")
+			}
+			a := newTestAddPath(fs)
+			statuses := make([]int, n)
+			var wg sync.WaitGroup
+			for i := 0; i < n; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					rec := do(a, "POST", "/add", fmt.Sprintf(`{"url": "https://example.com/%v"}`, i))
+					statuses[i] = rec.Code
+				}(i)
+			}
+			for i := 0; i < tt.removes; i++ {
+				wg.Add(2)
+				go func(i int) {
+					defer wg.Done()
+					if err := fs.Remove(fmt.Sprintf("old%v", i)); err != nil {
+						t.Errorf("remove old%v: %v", i, err)
+					}
+				}(i)
+				go func() {
+					defer wg.Done()
+					fs.GetByURL("https://example.com/0")
+				}()
+			}
+			wg.Wait()
+			for i, status := range statuses {
+				if status != http.StatusCreated {
+					t.Errorf("add %v: status = %v", i, status)
+				}
+			}
+
+			raw, err := os.ReadFile(fs.filenane)
+			if err != nil {
+				t.Fatal(err)
+			}
+			is, err := parseStore(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(is.Items) != n {
+				t.Errorf("file holds %v links, want %v", len(is.Items), n)
+			}
+			for i := 0; i < n; i++ {
+				want := fmt.Sprintf("https://example.com/%v", i)
+				codes, err := fs.GetByURL(want)
+				if err != nil || len(codes) != 1 {
+					t.Errorf("%v has codes %v, %v", want, codes, err)
+				}
+			}
+			if len(is.Removed) != tt.removes {
+				t.Errorf("file holds %v tombstones, want %v", len(is.Removed), tt.removes)
+			}
+		})
+	}
+}
+
+func TestAddPathTTL(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantStatus  int
+		wantExpires time.Duration
+	}{
+		{name: "no ttl", body: `{"url": "https://example.com/"}`, wantStatus: http.StatusCreated},
+		{name: "one day", body: `{"url": "https://example.com/", "ttl_seconds": 86400}`, wantStatus: http.StatusCreated, wantExpires: 24 * time.Hour},
+		{name: "negative", body: `{"url": "https://example.com/", "ttl_seconds": -1}`, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			rec := do(newTestAddPath(store), "POST", "/add", tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %v, want %v: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rec.Code != http.StatusCreated {
+				return
+			}
+			resp := decode(t, rec)
+			expires, ok := resp["expires_at"].(string)
+			if tt.wantExpires == 0 {
+				if ok {
+					t.Errorf("expires_at = %v for a permanent link", expires)
+				}
+				return
+			}
+			at, err := time.Parse(time.RFC3339, expires)
+			if err != nil {
+				t.Fatalf("expires_at = %#v: %v", resp["expires_at"], err)
+			}
+			if d := time.Until(at) - tt.wantExpires; d > time.Minute || d < -time.Minute {
+				t.Errorf("expires_at = %v, want about %v from now", at, tt.wantExpires)
+			}
+		})
+	}
+}
+
+func TestRedirectExpired(t *testing.T) {
+	stores := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store { return NewMemoryStore() },
+		"file":   func(t *testing.T) Store { return newTestFileStore(t, 0) },
+		"sql":    func(t *testing.T) Store { return newTestSQLStore(t) },
+	}
+	tests := []struct {
+		name       string
+		expiresAt  time.Time
+		wantStatus int
+	}{
+		{name: "permanent", wantStatus: http.StatusFound},
+		{name: "not yet expired", expiresAt: time.Now().Add(time.Hour), wantStatus: http.StatusFound},
+		{name: "expired", expiresAt: time.Now().Add(-time.Second), wantStatus: http.StatusNotFound},
+	}
+	for storeName, newStore := range stores {
+		for _, tt := range tests {
+			t.Run(storeName+"/"+tt.name, func(t *testing.T) {
+				store := newStore(t)
+				if err := store.Add("abc", Link{URL: "https://example.com/", ExpiresAt: tt.expiresAt}); err != nil {
+					t.Fatal(err)
+				}
+				p := &RedirectPath{store: store, redirectStatus: http.StatusFound}
+				rec := doHash(p, "GET", "abc", "")
+				if rec.Code != tt.wantStatus {
+					t.Errorf("status = %v, want %v", rec.Code, tt.wantStatus)
+				}
+				if tt.wantStatus == http.StatusNotFound {
+					codes, _ := store.GetByURL("https://example.com/")
+					if len(codes) != 0 {
+						t.Errorf("expired link still listed for its destination: %v", codes)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestFileStoreLoadsVersion1(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "store.json")
+	old := `{"version": "1.0", "items": {"abc": "https://example.com/", "def": "https://example.org/"}}`
+	if err := os.WriteFile(filename, []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fs, err := NewFileStore(filename, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	for code, want := range map[string]string{"abc": "https://example.com/", "def": "https://example.org/"} {
+		link, err := fs.Get(code)
+		if err != nil {
+			t.Fatalf("get %v: %v", code, err)
+		}
+		if link.URL != want || !link.ExpiresAt.IsZero() {
+			t.Errorf("%v = %+v, want %v with no expiry", code, link, want)
+		}
+	}
+
+	// The first write upgrades the file to the current version.
+	mustAdd(t, fs, "ghi", "https://example.net/")
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	is, err := parseStore(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if is.Version != storeVersion || len(is.Items) != 3 {
+		t.Errorf("saved version %v with %v links, want %v with 3", is.Version, len(is.Items), storeVersion)
+	}
+}
+
+func TestEnvOr(t *testing.T) {
+	tests := []struct {
+		name  string
+		set   bool
+		value string
+		want  string
+	}{
+		{name: "unset", want: "store.json"},
+		{name: "empty", set: true, value: "", want: "store.json"},
+		{name: "set", set: true, value: "/var/lib/urlshort/links.json", want: "/var/lib/urlshort/links.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("URLSHORT_STORE_FILE", tt.value)
+			if !tt.set {
+				os.Unsetenv("URLSHORT_STORE_FILE")
+			}
+			if got := envOr("URLSHORT_STORE_FILE", "store.json"); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddPathConfiguredDomain(t *testing.T) {
+	for _, domain := range []string{"http://localhost:8080", "https://go.example.com"} {
+		t.Run(domain, func(t *testing.T) {
+			t.Setenv("URLSHORT_DOMAIN", domain)
+			a := newTestAddPath(NewMemoryStore())
+			a.domain = envOr("URLSHORT_DOMAIN", "http://localhost:8080")
+			rec := do(a, "POST", "/add", `{"url": "https://example.com/", "alias": "abc"}`)
+			if rec.Code != http.StatusCreated {
+				t.Fatalf("status = %v: %s", rec.Code, rec.Body)
+			}
+			if got := decode(t, rec)["shortened_url"]; got != domain+"/abc" {
+				t.Errorf("shortened_url = %v, want %v", got, domain+"/abc")
+			}
+		})
+	}
+}
+
+func TestNewFileStoreCorrupt(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+	}{
+		{name: "truncated", contents: `{"version":"2.0","items":{"abc":{"url":"https://exa`},
+		{name: "empty", contents: ""},
+		{name: "garbage", contents: "\x00\x00\x00\x00"},
+		{name: "wrong shape", contents: `{"version":"2.0","items":["abc"]}`},
+		{name: "truncated 1.0", contents: `{"version":"1.0","items":{"abc":"https://`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "store.json")
+			if err := os.WriteFile(path, []byte(tt.contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+			fs, err := NewFileStore(path, 0, time.Hour)
+			if err == nil {
+				fs.Close()
+				t.Fatal("expected an error for a corrupt store")
+			}
+			if !strings.Contains(err.Error(), "is corrupt") || !strings.Contains(err.Error(), path) {
+				t.Errorf("error %q does not name the corrupt file", err)
+			}
+			raw, _ := os.ReadFile(path)
+			if string(raw) != tt.contents {
+				t.Errorf("corrupt file was modified: %q", raw)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencySamples is how many recent request durations are kept for
+// percentile estimates.
+const latencySamples = 1024
+
+// Sizer is implemented by stores that can report how many links they hold.
+type Sizer interface {
+	Len() (int, error)
+}
+
+// Metrics counts link operations and request outcomes. It is registered as
+// an EventHook for adds, deletes and redirects, and wraps the router to
+// see every response status and duration.
+type Metrics struct {
+	started   time.Time
+	store     Store
+	adds      atomic.Int64
+	deletes   atomic.Int64
+	redirects atomic.Int64
+	updates   atomic.Int64
+	notFound  atomic.Int64
+	errors    atomic.Int64
+
+	mu        sync.Mutex
+	latencies []time.Duration
+	next      int
+}
+
+func (m *Metrics) OnAdd(e Event)      { m.adds.Add(1) }
+func (m *Metrics) OnRemove(e Event)   { m.deletes.Add(1) }
+func (m *Metrics) OnRedirect(e Event) { m.redirects.Add(1) }
+func (m *Metrics) OnUpdate(e Event)   { m.updates.Add(1) }
+
+// Wrap returns next instrumented to record status codes and latency.
+func (m *Metrics) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		m.observe(rec.status, time.Since(start))
+	})
+}
+
+func (m *Metrics) observe(status int, d time.Duration) {
+	switch {
+	case status == http.StatusNotFound:
+		m.notFound.Add(1)
+	case status >= 500:
+		m.errors.Add(1)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.latencies) < latencySamples {
+		m.latencies = append(m.latencies, d)
+	} else {
+		m.latencies[m.next] = d
+	}
+	m.next = (m.next + 1) % latencySamples
+}
+
+// percentiles returns the p50, p90 and p99 of the recent latency samples.
+func (m *Metrics) percentiles() (p50, p90, p99 time.Duration) {
+	m.mu.Lock()
+	sorted := append([]time.Duration(nil), m.latencies...)
+	m.mu.Unlock()
+	if len(sorted) == 0 {
+		return 0, 0, 0
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	at := func(p float64) time.Duration {
+		return sorted[int(p*float64(len(sorted)-1))]
+	}
+	return at(0.5), at(0.9), at(0.99)
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	type latencyResponse struct {
+		P50 float64 `json:"p50_ms"`
+		P90 float64 `json:"p90_ms"`
+		P99 float64 `json:"p99_ms"`
+	}
+	type metricsResponse struct {
+		Adds          int64           `json:"adds"`
+		Deletes       int64           `json:"deletes"`
+		Redirects     int64           `json:"redirects"`
+		Updates       int64           `json:"updates"`
+		NotFound      int64           `json:"not_found"`
+		Errors        int64           `json:"errors"`
+		StoreSize     *int            `json:"store_size,omitempty"`
+		UptimeSeconds float64         `json:"uptime_seconds"`
+		Latency       latencyResponse `json:"latency"`
+	}
+
+	ms := func(d time.Duration) float64 {
+		return float64(d.Microseconds()) / 1000
+	}
+	p50, p90, p99 := m.percentiles()
+	resp := metricsResponse{
+		Adds:          m.adds.Load(),
+		Deletes:       m.deletes.Load(),
+		Redirects:     m.redirects.Load(),
+		Updates:       m.updates.Load(),
+		NotFound:      m.notFound.Load(),
+		Errors:        m.errors.Load(),
+		UptimeSeconds: time.Since(m.started).Seconds(),
+		Latency:       latencyResponse{P50: ms(p50), P90: ms(p90), P99: ms(p99)},
+	}
+	if s, ok := m.store.(Sizer); ok {
+		n, err := s.Len()
+		if err == nil {
+			resp.StoreSize = &n
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func NewMetrics(store Store) *Metrics {
+	return &Metrics{started: time.Now(), store: store}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func newMetricsRouter(store Store) (*Metrics, http.Handler) {
+	metrics := NewMetrics(store)
+	hooks := Hooks{metrics}
+	add := newTestAddPath(store)
+	add.hooks = hooks
+	r := mux.NewRouter()
+	r.Handle("/admin/metrics.json", metrics).Methods("GET")
+	r.Handle("/add", add).Methods("POST")
+	r.Handle("/fail", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSONError(w, http.StatusInternalServerError, "boom")
+	}))
+	r.Handle("/{hash}", &DeletePath{store: store, hooks: hooks}).Methods("DELETE")
+	r.Handle("/{hash}", &RedirectPath{store: store, hooks: hooks, redirectStatus: http.StatusFound}).Methods("GET")
+	return metrics, metrics.Wrap(r)
+}
+
+func TestMetricsShape(t *testing.T) {
+	_, h := newMetricsRouter(NewMemoryStore())
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/admin/metrics.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %v", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Errorf("content type = %q", ct)
+	}
+	got := decode(t, rec)
+	for _, key := range []string{"adds", "deletes", "redirects", "updates", "not_found", "errors", "store_size", "uptime_seconds"} {
+		if _, ok := got[key].(float64); !ok {
+			t.Errorf("%v = %#v, want a number", key, got[key])
+		}
+	}
+	latency, ok := got["latency"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("latency = %#v, want an object", got["latency"])
+	}
+	for _, key := range []string{"p50_ms", "p90_ms", "p99_ms"} {
+		if _, ok := latency[key].(float64); !ok {
+			t.Errorf("latency.%v = %#v, want a number", key, latency[key])
+		}
+	}
+}
+
+func TestMetricsCounters(t *testing.T) {
+	store := NewMemoryStore()
+	_, h := newMetricsRouter(store)
+	requests := []struct {
+		method, target, body string
+	}{
+		{"POST", "/add", `{"url": "https://example.com/a", "alias": "aaa"}`},
+		{"POST", "/add", `{"url": "https://example.com/b", "alias": "bbb"}`},
+		{"GET", "/aaa", ""},
+		{"GET", "/aaa", ""},
+		{"GET", "/aaa", ""},
+		{"GET", "/missing", ""},
+		{"DELETE", "/bbb", ""},
+		{"GET", "/fail", ""},
+	}
+	for _, req := range requests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(req.method, req.target, strings.NewReader(req.body)))
+	}
+
+	want := map[string]float64{
+		"adds":       2,
+		"deletes":    1,
+		"redirects":  3,
+		"not_found":  1,
+		"errors":     1,
+		"store_size": 1,
+	}
+	// Hooks run in their own goroutines, so give them a moment to land.
+	var got map[string]interface{}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/admin/metrics.json", nil))
+		got = nil
+		json.Unmarshal(rec.Body.Bytes(), &got)
+		if matches(got, want) {
+			break
+		}
+	}
+	for key, v := range want {
+		if got[key] != v {
+			t.Errorf("%v = %v, want %v", key, got[key], v)
+		}
+	}
+	if p99 := got["latency"].(map[string]interface{})["p99_ms"].(float64); p99 <= 0 {
+		t.Errorf("p99_ms = %v, want a positive duration", p99)
+	}
+}
+
+func matches(got map[string]interface{}, want map[string]float64) bool {
+	for key, v := range want {
+		if got[key] != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// QueryMode controls what RedirectPath does with a query string appended to
+// a short link.
+type QueryMode int
+
+const (
+	// QueryDrop ignores the incoming query string.
+	QueryDrop QueryMode = iota
+	// QueryAppend adds the incoming parameters after the destination's own,
+	// keeping duplicates.
+	QueryAppend
+	// QueryMerge adds the incoming parameters to the destination's,
+	// replacing any destination parameter with the same name.
+	QueryMerge
+)
+
+func ParseQueryMode(s string) (QueryMode, error) {
+	switch s {
+	case "drop":
+		return QueryDrop, nil
+	case "append":
+		return QueryAppend, nil
+	case "merge":
+		return QueryMerge, nil
+	}
+	return QueryDrop, fmt.Errorf("unknown query mode %q", s)
+}
+
+// forwardQuery returns longURL with rawQuery applied according to mode.
+func forwardQuery(longURL, rawQuery string, mode QueryMode) (string, error) {
+	if mode == QueryDrop || rawQuery == "" {
+		return longURL, nil
+	}
+	u, err := url.Parse(longURL)
+	if err != nil {
+		return "", err
+	}
+	incoming, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", err
+	}
+
+	switch mode {
+	case QueryAppend:
+		if u.RawQuery == "" {
+			u.RawQuery = incoming.Encode()
+		} else {
+			u.RawQuery = u.RawQuery + "&" + incoming.Encode()
+		}
+	case QueryMerge:
+		existing := u.Query()
+		for k, v := range incoming {
+			existing[k] = v
+		}
+		u.RawQuery = existing.Encode()
+	}
+	return u.String(), nil
+}
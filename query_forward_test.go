@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestRedirectQueryMode(t *testing.T) {
+	tests := []struct {
+		name         string
+		mode         string
+		longURL      string
+		query        string
+		wantLocation string
+	}{
+		{name: "drop", mode: "drop", longURL: "https://example.com/p", query: "ref=tw", wantLocation: "https://example.com/p"},
+		{name: "drop with destination query", mode: "drop", longURL: "https://example.com/p?a=1", query: "ref=tw", wantLocation: "https://example.com/p?a=1"},
+		{name: "append", mode: "append", longURL: "https://example.com/p", query: "ref=tw", wantLocation: "https://example.com/p?ref=tw"},
+		{name: "append with destination query", mode: "append", longURL: "https://example.com/p?a=1", query: "a=2&ref=tw", wantLocation: "https://example.com/p?a=1&a=2&ref=tw"},
+		{name: "merge", mode: "merge", longURL: "https://example.com/p", query: "ref=tw", wantLocation: "https://example.com/p?ref=tw"},
+		{name: "merge with destination query", mode: "merge", longURL: "https://example.com/p?a=1&b=2", query: "a=3", wantLocation: "https://example.com/p?a=3&b=2"},
+		{name: "encoding is kept", mode: "append", longURL: "https://example.com/p", query: "q=a+b%26c", wantLocation: "https://example.com/p?q=a+b%26c"},
+		{name: "no query", mode: "merge", longURL: "https://example.com/p?a=1", wantLocation: "https://example.com/p?a=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mode, err := ParseQueryMode(tt.mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			store := NewMemoryStore()
+			store.Add("abc", Link{URL: tt.longURL})
+			p := &RedirectPath{store: store, queryMode: mode, redirectStatus: http.StatusTemporaryRedirect}
+			target := "/abc"
+			if tt.query != "" {
+				target += "?" + tt.query
+			}
+			req := mux.SetURLVars(httptest.NewRequest("GET", target, nil), map[string]string{"hash": "abc"})
+			rec := httptest.NewRecorder()
+			p.ServeHTTP(rec, req)
+			if rec.Code != http.StatusTemporaryRedirect {
+				t.Fatalf("status = %v: %s", rec.Code, rec.Body)
+			}
+			if got := rec.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+		})
+	}
+}
+
+func TestParseQueryModeUnknown(t *testing.T) {
+	_, err := ParseQueryMode("forward")
+	if err == nil {
+		t.Error("got no error for an unknown mode")
+	}
+}
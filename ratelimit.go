@@ -0,0 +1,141 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+type limiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// KeyedLimiter keeps a token bucket per key (a short code, a client IP,
+// ...). Buckets idle for longer than idleTTL are evicted so memory stays
+// bounded by the number of recently active keys.
+type KeyedLimiter struct {
+	mu      sync.Mutex
+	entries map[string]*limiterEntry
+	limit   rate.Limit
+	burst   int
+	idleTTL time.Duration
+}
+
+// Allow takes a token from key's bucket. When the bucket is empty it
+// reports how long the caller should wait before retrying.
+func (k *KeyedLimiter) Allow(key string) (bool, time.Duration) {
+	return k.allow(key, k.limit, k.burst)
+}
+
+// AllowOverride is Allow for a key with its own sustained rate and burst.
+// Zero values fall back to the limiter's, and a key left with no rate at
+// all is not limited.
+func (k *KeyedLimiter) AllowOverride(key string, perSecond float64, burst int) (bool, time.Duration) {
+	limit := k.limit
+	if perSecond > 0 {
+		limit = rate.Limit(perSecond)
+	}
+	if burst == 0 {
+		burst = k.burst
+	}
+	if limit == 0 {
+		return true, 0
+	}
+	return k.allow(key, limit, burst)
+}
+
+// allow takes a token from key's bucket, first resizing the bucket if its
+// rate or burst changed since it was created.
+func (k *KeyedLimiter) allow(key string, limit rate.Limit, burst int) (bool, time.Duration) {
+	k.mu.Lock()
+	e, ok := k.entries[key]
+	if !ok {
+		e = &limiterEntry{limiter: rate.NewLimiter(limit, burst)}
+		k.entries[key] = e
+	} else if e.limiter.Limit() != limit || e.limiter.Burst() != burst {
+		e.limiter.SetLimit(limit)
+		e.limiter.SetBurst(burst)
+	}
+	e.lastSeen = time.Now()
+	k.mu.Unlock()
+
+	r := e.limiter.Reserve()
+	if !r.OK() {
+		return false, time.Second
+	}
+	delay := r.Delay()
+	if delay > 0 {
+		r.Cancel()
+		return false, delay
+	}
+	return true, 0
+}
+
+func (k *KeyedLimiter) evictIdle() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	cutoff := time.Now().Add(-k.idleTTL)
+	for key, e := range k.entries {
+		if e.lastSeen.Before(cutoff) {
+			delete(k.entries, key)
+		}
+	}
+}
+
+// NewKeyedLimiter allows perSecond sustained requests per key with bursts
+// of up to burst. A perSecond of 0 denies every Allow; AllowOverride
+// treats it as unlimited.
+func NewKeyedLimiter(perSecond float64, burst int, idleTTL time.Duration) *KeyedLimiter {
+	k := &KeyedLimiter{
+		entries: make(map[string]*limiterEntry),
+		limit:   rate.Limit(perSecond),
+		burst:   burst,
+		idleTTL: idleTTL,
+	}
+	go func() {
+		for range time.Tick(idleTTL) {
+			k.evictIdle()
+		}
+	}()
+	return k
+}
+
+// ClientRateLimit limits requests per client IP. The IP is the request's
+// remote address, or the first X-Forwarded-For entry when trustForwarded
+// is set because the server sits behind a proxy that sets that header.
+type ClientRateLimit struct {
+	limiter        *KeyedLimiter
+	trustForwarded bool
+	next           http.Handler
+}
+
+func (c *ClientRateLimit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ok, retryAfter := c.limiter.Allow(c.clientIP(r))
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		writeJSONError(w, http.StatusTooManyRequests, "too many requests from this client")
+		return
+	}
+	c.next.ServeHTTP(w, r)
+}
+
+func (c *ClientRateLimit) clientIP(r *http.Request) string {
+	if c.trustForwarded {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			first, _, _ := strings.Cut(fwd, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedirectLimitBurstAndRefill(t *testing.T) {
+	store := NewMemoryStore()
+	mustAdd(t, store, "abc", "https://example.com/abc")
+	mustAdd(t, store, "other", "https://example.com/other")
+	p := &RedirectPath{
+		store:          store,
+		redirectStatus: http.StatusFound,
+		limiter:        NewKeyedLimiter(10, 3, time.Minute),
+	}
+
+	steps := []struct {
+		name       string
+		wait       time.Duration
+		hash       string
+		wantStatus int
+	}{
+		{name: "burst 1", hash: "abc", wantStatus: http.StatusFound},
+		{name: "burst 2", hash: "abc", wantStatus: http.StatusFound},
+		{name: "burst 3", hash: "abc", wantStatus: http.StatusFound},
+		{name: "bucket empty", hash: "abc", wantStatus: http.StatusTooManyRequests},
+		{name: "other codes have their own bucket", hash: "other", wantStatus: http.StatusFound},
+		{name: "one token refilled", wait: 150 * time.Millisecond, hash: "abc", wantStatus: http.StatusFound},
+		{name: "empty again", hash: "abc", wantStatus: http.StatusTooManyRequests},
+	}
+	for _, s := range steps {
+		time.Sleep(s.wait)
+		rec := doHash(p, "GET", s.hash, "")
+		if rec.Code != s.wantStatus {
+			t.Fatalf("%v: status = %v, want %v", s.name, rec.Code, s.wantStatus)
+		}
+		if s.wantStatus == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+			t.Errorf("%v: no Retry-After header", s.name)
+		}
+	}
+}
+
+func TestRedirectLimitPerLink(t *testing.T) {
+	store := NewMemoryStore()
+	if err := store.Add("abc", Link{URL: "https://example.com/abc", RedirectRate: 10, RedirectBurst: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Add("burst", Link{URL: "https://example.com/burst", RedirectBurst: 4}); err != nil {
+		t.Fatal(err)
+	}
+	mustAdd(t, store, "plain", "https://example.com/plain")
+
+	tests := []struct {
+		name string
+		// linkRate and linkBurst are the -link-rate and -link-burst
+		// defaults.
+		linkRate  float64
+		linkBurst int
+		hash      string
+		// allowed is how many redirects pass before the bucket is empty;
+		// 0 means the code is never limited.
+		allowed int
+	}{
+		{name: "own rate without a default", hash: "abc", linkBurst: 10, allowed: 2},
+		{name: "own rate over the default", hash: "abc", linkRate: 1, linkBurst: 10, allowed: 2},
+		{name: "own burst at the default rate", hash: "burst", linkRate: 10, linkBurst: 1, allowed: 4},
+		{name: "own burst without any rate", hash: "burst", linkBurst: 1},
+		{name: "default", hash: "plain", linkRate: 10, linkBurst: 3, allowed: 3},
+		{name: "no limit at all", hash: "plain", linkBurst: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &RedirectPath{
+				store:          store,
+				redirectStatus: http.StatusFound,
+				limiter:        NewKeyedLimiter(tt.linkRate, tt.linkBurst, time.Minute),
+			}
+			requests := tt.allowed + 1
+			if tt.allowed == 0 {
+				requests = 20
+			}
+			for i := 0; i < requests; i++ {
+				want := http.StatusFound
+				if tt.allowed > 0 && i == tt.allowed {
+					want = http.StatusTooManyRequests
+				}
+				if rec := doHash(p, "GET", tt.hash, ""); rec.Code != want {
+					t.Fatalf("request %v: status = %v, want %v", i+1, rec.Code, want)
+				}
+			}
+			if tt.allowed == 0 {
+				return
+			}
+			// Every limited case runs at 10/s, so a token is back within
+			// 150ms.
+			time.Sleep(150 * time.Millisecond)
+			if rec := doHash(p, "GET", tt.hash, ""); rec.Code != http.StatusFound {
+				t.Errorf("after refill: status = %v", rec.Code)
+			}
+		})
+	}
+}
+
+func TestAddPathRedirectLimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantRate   float64
+		wantBurst  int
+	}{
+		{name: "both", body: `{"url": "https://example.com/", "redirect_rate": 2.5, "redirect_burst": 5}`, wantStatus: http.StatusCreated, wantRate: 2.5, wantBurst: 5},
+		{name: "burst only", body: `{"url": "https://example.com/", "redirect_burst": 5}`, wantStatus: http.StatusCreated, wantBurst: 5},
+		{name: "none", body: `{"url": "https://example.com/"}`, wantStatus: http.StatusCreated},
+		{name: "negative rate", body: `{"url": "https://example.com/", "redirect_rate": -1}`, wantStatus: http.StatusBadRequest},
+		{name: "negative burst", body: `{"url": "https://example.com/", "redirect_burst": -1}`, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			rec := do(newTestAddPath(store), "POST", "/add", tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %v, want %v: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rec.Code != http.StatusCreated {
+				return
+			}
+			records, err := store.List(1, 0)
+			if err != nil || len(records) != 1 {
+				t.Fatalf("listed %+v, %v", records, err)
+			}
+			if got := records[0].Link; got.RedirectRate != tt.wantRate || got.RedirectBurst != tt.wantBurst {
+				t.Errorf("stored rate %v burst %v, want %v and %v", got.RedirectRate, got.RedirectBurst, tt.wantRate, tt.wantBurst)
+			}
+		})
+	}
+}
+
+func TestKeyedLimiterEvictsIdle(t *testing.T) {
+	k := &KeyedLimiter{entries: make(map[string]*limiterEntry), limit: 1, burst: 1, idleTTL: time.Minute}
+	k.Allow("old")
+	k.Allow("new")
+	k.entries["old"].lastSeen = time.Now().Add(-2 * time.Minute)
+	k.evictIdle()
+	if _, ok := k.entries["old"]; ok {
+		t.Errorf("idle bucket kept")
+	}
+	if _, ok := k.entries["new"]; !ok {
+		t.Errorf("active bucket evicted")
+	}
+}
+
+func TestClientRateLimit(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustForwarded bool
+		// requests are sent in order as remote address, X-Forwarded-For.
+		requests   [][2]string
+		wantStatus []int
+	}{
+		{
+			name:       "under the limit",
+			requests:   [][2]string{{"192.0.2.1:1000", ""}, {"192.0.2.1:1001", ""}},
+			wantStatus: []int{http.StatusCreated, http.StatusCreated},
+		},
+		{
+			name:       "burst past the limit",
+			requests:   [][2]string{{"192.0.2.1:1000", ""}, {"192.0.2.1:1001", ""}, {"192.0.2.1:1002", ""}},
+			wantStatus: []int{http.StatusCreated, http.StatusCreated, http.StatusTooManyRequests},
+		},
+		{
+			name:       "clients are limited separately",
+			requests:   [][2]string{{"192.0.2.1:1000", ""}, {"192.0.2.1:1001", ""}, {"192.0.2.2:1000", ""}},
+			wantStatus: []int{http.StatusCreated, http.StatusCreated, http.StatusCreated},
+		},
+		{
+			name:       "forwarded header ignored by default",
+			requests:   [][2]string{{"10.0.0.1:1000", "192.0.2.1"}, {"10.0.0.1:1001", "192.0.2.2"}, {"10.0.0.1:1002", "192.0.2.3"}},
+			wantStatus: []int{http.StatusCreated, http.StatusCreated, http.StatusTooManyRequests},
+		},
+		{
+			name:           "forwarded header identifies clients behind a proxy",
+			trustForwarded: true,
+			requests:       [][2]string{{"10.0.0.1:1000", "192.0.2.1"}, {"10.0.0.1:1001", "192.0.2.1, 10.0.0.9"}, {"10.0.0.1:1002", "192.0.2.2"}, {"10.0.0.1:1003", " 192.0.2.1 "}},
+			wantStatus:     []int{http.StatusCreated, http.StatusCreated, http.StatusCreated, http.StatusTooManyRequests},
+		},
+		{
+			name:           "no forwarded header falls back to the remote address",
+			trustForwarded: true,
+			requests:       [][2]string{{"192.0.2.1:1000", ""}, {"192.0.2.1:1001", ""}, {"192.0.2.1:1002", ""}},
+			wantStatus:     []int{http.StatusCreated, http.StatusCreated, http.StatusTooManyRequests},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &ClientRateLimit{
+				// One request per minute with a burst of two: nothing
+				// refills during the test.
+				limiter:        NewKeyedLimiter(1.0/60, 2, time.Minute),
+				trustForwarded: tt.trustForwarded,
+				next:           newTestAddPath(NewMemoryStore()),
+			}
+			for i, req := range tt.requests {
+				r := httptest.NewRequest("POST", "/add", strings.NewReader(fmt.Sprintf(`{"url": "https://example.com/%v"}`, i)))
+				r.RemoteAddr = req[0]
+				if req[1] != "" {
+					r.Header.Set("X-Forwarded-For", req[1])
+				}
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, r)
+				if rec.Code != tt.wantStatus[i] {
+					t.Fatalf("request %v: status = %v, want %v: %s", i, rec.Code, tt.wantStatus[i], rec.Body)
+				}
+				if rec.Code != http.StatusTooManyRequests {
+					continue
+				}
+				if retry, err := strconv.Atoi(rec.Header().Get("Retry-After")); err != nil || retry < 1 {
+					t.Errorf("Retry-After = %q", rec.Header().Get("Retry-After"))
+				}
+				if decode(t, rec)["error"] == nil {
+					t.Errorf("429 without a JSON error: %s", rec.Body)
+				}
+			}
+		})
+	}
+}
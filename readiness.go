@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+)
+
+type subsystemStatus struct {
+	Ready  bool   `json:"ready"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Readiness aggregates the state of the subsystems the service needs before
+// it can take traffic. It reports ready only once every registered
+// subsystem is.
+type Readiness struct {
+	mu         sync.Mutex
+	subsystems map[string]subsystemStatus
+	// probes report a subsystem's state on demand instead of via Set.
+	probes map[string]func() (bool, string)
+}
+
+// Register adds a subsystem in the not-ready state.
+func (rd *Readiness) Register(name string) {
+	rd.Set(name, false, "starting")
+}
+
+func (rd *Readiness) Set(name string, ready bool, detail string) {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	rd.subsystems[name] = subsystemStatus{Ready: ready, Detail: detail}
+}
+
+// Probe registers fn to be asked for name's state whenever readiness is
+// evaluated.
+func (rd *Readiness) Probe(name string, fn func() (ready bool, detail string)) {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	rd.probes[name] = fn
+}
+
+// refresh runs the probes. rd.mu must be held.
+func (rd *Readiness) refresh() {
+	for name, fn := range rd.probes {
+		ready, detail := fn()
+		rd.subsystems[name] = subsystemStatus{Ready: ready, Detail: detail}
+	}
+}
+
+func (rd *Readiness) Ready() bool {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	rd.refresh()
+	for _, s := range rd.subsystems {
+		if !s.Ready {
+			return false
+		}
+	}
+	return true
+}
+
+// ServeHTTP answers 200 when ready and 503 otherwise, listing each
+// subsystem's state in the body.
+func (rd *Readiness) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rd.mu.Lock()
+	rd.refresh()
+	names := make([]string, 0, len(rd.subsystems))
+	for name := range rd.subsystems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	type subsystemEntry struct {
+		Name string `json:"name"`
+		subsystemStatus
+	}
+	resp := struct {
+		Ready      bool             `json:"ready"`
+		Subsystems []subsystemEntry `json:"subsystems"`
+	}{Ready: true}
+	for _, name := range names {
+		s := rd.subsystems[name]
+		resp.Ready = resp.Ready && s.Ready
+		resp.Subsystems = append(resp.Subsystems, subsystemEntry{Name: name, subsystemStatus: s})
+	}
+	rd.mu.Unlock()
+
+	status := http.StatusOK
+	if !resp.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, resp)
+}
+
+func NewReadiness() *Readiness {
+	return &Readiness{
+		subsystems: make(map[string]subsystemStatus),
+		probes:     make(map[string]func() (bool, string)),
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestReadiness(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup configures the coordinator; store is registered first.
+		setup      func(rd *Readiness)
+		wantStatus int
+		wantStates map[string]bool
+	}{
+		{
+			name:       "registered subsystem starts not ready",
+			setup:      func(rd *Readiness) {},
+			wantStatus: http.StatusServiceUnavailable,
+			wantStates: map[string]bool{"store": false},
+		},
+		{
+			name: "partially ready",
+			setup: func(rd *Readiness) {
+				rd.Register("warmup")
+				rd.Set("store", true, "open")
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantStates: map[string]bool{"store": true, "warmup": false},
+		},
+		{
+			name: "all ready",
+			setup: func(rd *Readiness) {
+				rd.Register("warmup")
+				rd.Set("store", true, "open")
+				rd.Set("warmup", true, "done")
+			},
+			wantStatus: http.StatusOK,
+			wantStates: map[string]bool{"store": true, "warmup": true},
+		},
+		{
+			name: "probe reports ready",
+			setup: func(rd *Readiness) {
+				rd.Probe("store", func() (bool, string) { return true, "reachable" })
+			},
+			wantStatus: http.StatusOK,
+			wantStates: map[string]bool{"store": true},
+		},
+		{
+			name: "probe reports not ready",
+			setup: func(rd *Readiness) {
+				rd.Set("store", true, "open")
+				rd.Probe("replica", func() (bool, string) { return false, "lagging" })
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantStates: map[string]bool{"store": true, "replica": false},
+		},
+		{
+			name: "going back to not ready",
+			setup: func(rd *Readiness) {
+				rd.Set("store", true, "open")
+				rd.Set("store", false, "draining")
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantStates: map[string]bool{"store": false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rd := NewReadiness()
+			rd.Register("store")
+			tt.setup(rd)
+			rec := do(rd, "GET", "/healthz", "")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %v, want %v: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rd.Ready() != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("Ready() disagrees with the status")
+			}
+			var resp struct {
+				Subsystems []struct {
+					Name   string `json:"name"`
+					Ready  bool   `json:"ready"`
+					Detail string `json:"detail"`
+				} `json:"subsystems"`
+			}
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			got := make(map[string]bool)
+			for _, s := range resp.Subsystems {
+				got[s.Name] = s.Ready
+				if s.Detail == "" {
+					t.Errorf("%v has no detail", s.Name)
+				}
+			}
+			if len(got) != len(tt.wantStates) {
+				t.Errorf("subsystems = %v, want %v", got, tt.wantStates)
+			}
+			for name, want := range tt.wantStates {
+				if got[name] != want {
+					t.Errorf("%v ready = %v, want %v", name, got[name], want)
+				}
+			}
+		})
+	}
+}
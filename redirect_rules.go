@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+type redirectRule struct {
+	pattern string
+	status  int
+}
+
+// RedirectRules maps destination host patterns to the status code used
+// when redirecting to them. Patterns use path.Match syntax, so
+// "*.example.com" matches any subdomain. The first matching rule wins.
+type RedirectRules []redirectRule
+
+func (rs *RedirectRules) String() string {
+	var parts []string
+	for _, r := range *rs {
+		parts = append(parts, fmt.Sprintf("%v=%v", r.pattern, r.status))
+	}
+	return strings.Join(parts, ",")
+}
+
+// Set parses a rule of the form "host-pattern=status".
+func (rs *RedirectRules) Set(value string) error {
+	pattern, rawStatus, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("redirect rule %q must look like host=status", value)
+	}
+	_, err := path.Match(pattern, "")
+	if err != nil {
+		return fmt.Errorf("bad host pattern %q: %v", pattern, err)
+	}
+	status, err := strconv.Atoi(rawStatus)
+	if err != nil || !isRedirectStatus(status) {
+		return fmt.Errorf("redirect rule %q has an invalid redirect status", value)
+	}
+	*rs = append(*rs, redirectRule{pattern: strings.ToLower(pattern), status: status})
+	return nil
+}
+
+// Status returns the status for a redirect to longURL, or def when no rule
+// matches its host.
+func (rs RedirectRules) Status(longURL string, def int) int {
+	u, err := url.Parse(longURL)
+	if err != nil {
+		return def
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, r := range rs {
+		if ok, _ := path.Match(r.pattern, host); ok {
+			return r.status
+		}
+	}
+	return def
+}
+
+func isRedirectStatus(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRedirectRules(t *testing.T) {
+	tests := []struct {
+		name       string
+		rules      []string
+		longURL    string
+		wantStatus int
+	}{
+		{name: "no rules uses the default", longURL: "https://example.com/", wantStatus: http.StatusTemporaryRedirect},
+		{name: "exact host", rules: []string{"example.com=301"}, longURL: "https://example.com/", wantStatus: http.StatusMovedPermanently},
+		{name: "host is case-insensitive", rules: []string{"Example.com=301"}, longURL: "https://EXAMPLE.com/", wantStatus: http.StatusMovedPermanently},
+		{name: "port is ignored", rules: []string{"example.com=301"}, longURL: "https://example.com:8443/", wantStatus: http.StatusMovedPermanently},
+		{name: "wildcard subdomain", rules: []string{"*.example.com=308"}, longURL: "https://shop.example.com/", wantStatus: http.StatusPermanentRedirect},
+		{name: "wildcard does not match apex", rules: []string{"*.example.com=308"}, longURL: "https://example.com/", wantStatus: http.StatusTemporaryRedirect},
+		{name: "other host uses the default", rules: []string{"example.com=301"}, longURL: "https://example.org/", wantStatus: http.StatusTemporaryRedirect},
+		{name: "first match wins", rules: []string{"*.example.com=301", "shop.example.com=302"}, longURL: "https://shop.example.com/", wantStatus: http.StatusMovedPermanently},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rules RedirectRules
+			for _, r := range tt.rules {
+				if err := rules.Set(r); err != nil {
+					t.Fatal(err)
+				}
+			}
+			store := NewMemoryStore()
+			mustAdd(t, store, "abc", tt.longURL)
+			p := &RedirectPath{store: store, redirectRules: rules, redirectStatus: http.StatusTemporaryRedirect}
+			rec := doHash(p, "GET", "abc", "")
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %v, want %v", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestRedirectRulesInvalid(t *testing.T) {
+	for _, value := range []string{"example.com", "example.com=200", "example.com=abc", "[=301"} {
+		t.Run(value, func(t *testing.T) {
+			var rules RedirectRules
+			if err := rules.Set(value); err == nil {
+				t.Errorf("got no error")
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+type rewriteChange struct {
+	Code string `json:"code"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Rewriter is implemented by stores that can rewrite many destinations in
+// a single pass. fn returns the new destination and whether it changed;
+// if it fails for any link nothing is rewritten and its error is returned.
+// With dryRun set the store is left untouched and the changes that would
+// be made are returned.
+type Rewriter interface {
+	Rewrite(fn func(code, longURL string) (string, bool, error), dryRun bool) ([]rewriteChange, error)
+}
+
+// RewritePath migrates stored destinations, either by swapping one host
+// for another or by a regular expression replacement. Every rewritten
+// destination must pass the same checks as POST /add.
+type RewritePath struct {
+	store Store
+	add   *AddPath
+	hooks Hooks
+}
+
+func (p *RewritePath) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	type rewriteRequest struct {
+		FromHost    string `json:"from_host"`
+		ToHost      string `json:"to_host"`
+		Pattern     string `json:"pattern"`
+		Replacement string `json:"replacement"`
+		DryRun      bool   `json:"dry_run"`
+	}
+
+	var parsed rewriteRequest
+	err := json.NewDecoder(r.Body).Decode(&parsed)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("unable to parse request: %v", err))
+		return
+	}
+
+	var fn func(code, longURL string) (string, bool)
+	switch {
+	case parsed.FromHost != "" && parsed.Pattern == "":
+		fn = rewriteHost(parsed.FromHost, parsed.ToHost)
+	case parsed.Pattern != "" && parsed.FromHost == "":
+		re, err := regexp.Compile(parsed.Pattern)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid pattern: %v", err))
+			return
+		}
+		fn = rewriteRegexp(re, parsed.Replacement)
+	default:
+		writeJSONError(w, http.StatusBadRequest, "exactly one of from_host or pattern is required")
+		return
+	}
+
+	rw, ok := p.store.(Rewriter)
+	if !ok {
+		writeJSONError(w, http.StatusNotImplemented, "the configured store cannot rewrite destinations")
+		return
+	}
+	changes, err := rw.Rewrite(p.checked(fn), parsed.DryRun)
+	if errors.Is(err, ErrDestinationNotAllowed) {
+		writeJSONError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	var reqErr requestError
+	if errors.As(err, &reqErr) {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("unexpected error: %v", err))
+		return
+	}
+	if !parsed.DryRun {
+		for _, c := range changes {
+			p.hooks.Update(newEvent(r, c.Code, c.To))
+		}
+	}
+
+	type rewriteResponse struct {
+		DryRun  bool            `json:"dry_run"`
+		Changed int             `json:"changed"`
+		Changes []rewriteChange `json:"changes"`
+	}
+	resp := rewriteResponse{DryRun: parsed.DryRun, Changed: len(changes), Changes: changes}
+	if resp.Changes == nil {
+		resp.Changes = []rewriteChange{}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// checked runs each destination fn rewrites through AddPath.destination,
+// failing the rewrite for the first one it rejects.
+func (p *RewritePath) checked(fn func(code, longURL string) (string, bool)) func(code, longURL string) (string, bool, error) {
+	return func(code, longURL string) (string, bool, error) {
+		rewritten, ok := fn(code, longURL)
+		if !ok {
+			return longURL, false, nil
+		}
+		checked, err := p.add.destination(rewritten)
+		if errors.Is(err, ErrDestinationNotAllowed) {
+			return "", false, fmt.Errorf("%w: %v rewritten to %v", err, code, rewritten)
+		}
+		if err != nil {
+			return "", false, requestError(fmt.Sprintf("%v rewritten to %v: %v", code, rewritten, err))
+		}
+		return checked, true, nil
+	}
+}
+
+func rewriteHost(from, to string) func(code, longURL string) (string, bool) {
+	return func(code, longURL string) (string, bool) {
+		if isEncrypted(longURL) {
+			return longURL, false
+		}
+		u, err := url.Parse(longURL)
+		if err != nil || !strings.EqualFold(u.Host, from) {
+			return longURL, false
+		}
+		u.Host = to
+		return u.String(), true
+	}
+}
+
+func rewriteRegexp(re *regexp.Regexp, replacement string) func(code, longURL string) (string, bool) {
+	return func(code, longURL string) (string, bool) {
+		if isEncrypted(longURL) || !re.MatchString(longURL) {
+			return longURL, false
+		}
+		rewritten := re.ReplaceAllString(longURL, replacement)
+		return rewritten, rewritten != longURL
+	}
+}
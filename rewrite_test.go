@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestRewritePath(t *testing.T) {
+	seed := map[string]string{
+		"a":   "https://old.example.com/a?x=1",
+		"b":   "https://OLD.example.com/b",
+		"c":   "https://sub.old.example.com/c",
+		"d":   "https://other.example.org/d",
+		"enc": encryptedPrefix + "c2VjcmV0LWRlc3RpbmF0aW9uLWNpcGhlcnRleHQtYnl0ZXM",
+	}
+	tests := []struct {
+		name        string
+		body        string
+		httpsOnly   bool
+		allow       string
+		wantStatus  int
+		wantChanged int
+		// want holds the destinations expected afterwards; codes not
+		// listed keep their seeded destination.
+		want map[string]string
+	}{
+		{
+			name:        "host dry run",
+			body:        `{"from_host": "old.example.com", "to_host": "new.example.com", "dry_run": true}`,
+			wantStatus:  http.StatusOK,
+			wantChanged: 2,
+		},
+		{
+			name:        "host apply",
+			body:        `{"from_host": "old.example.com", "to_host": "new.example.com"}`,
+			wantStatus:  http.StatusOK,
+			wantChanged: 2,
+			want: map[string]string{
+				"a": "https://new.example.com/a?x=1",
+				"b": "https://new.example.com/b",
+			},
+		},
+		{
+			name:        "regex apply",
+			body:        `{"pattern": "^https://([a-z.]*)old\\.example\\.com/(.*)$", "replacement": "https://${1}new.example.com/v2/$2"}`,
+			wantStatus:  http.StatusOK,
+			wantChanged: 2,
+			want: map[string]string{
+				"a": "https://new.example.com/v2/a?x=1",
+				"c": "https://sub.new.example.com/v2/c",
+			},
+		},
+		{
+			name:        "regex dry run",
+			body:        `{"pattern": "example\\.org", "replacement": "example.net", "dry_run": true}`,
+			wantStatus:  http.StatusOK,
+			wantChanged: 1,
+		},
+		{
+			name:        "no matches",
+			body:        `{"from_host": "missing.example.com", "to_host": "new.example.com"}`,
+			wantStatus:  http.StatusOK,
+			wantChanged: 0,
+		},
+		{
+			name:        "https-only accepts https rewrites",
+			body:        `{"from_host": "old.example.com", "to_host": "new.example.com"}`,
+			httpsOnly:   true,
+			wantStatus:  http.StatusOK,
+			wantChanged: 2,
+			want: map[string]string{
+				"a": "https://new.example.com/a?x=1",
+				"b": "https://new.example.com/b",
+			},
+		},
+		{
+			name:       "one rejected destination rejects the whole rewrite",
+			body:       `{"pattern": "^https://(old|other)\\.", "replacement": "http://$1."}`,
+			httpsOnly:  true,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "rewritten destination not allowed",
+			body:       `{"from_host": "old.example.com", "to_host": "evil.com"}`,
+			allow:      `https://[a-z.]*example\.(com|org)/.*`,
+			wantStatus: http.StatusForbidden,
+		},
+		{name: "invalid pattern", body: `{"pattern": "("}`, wantStatus: http.StatusBadRequest},
+		{name: "host and pattern", body: `{"from_host": "a", "pattern": "b"}`, wantStatus: http.StatusBadRequest},
+		{name: "neither", body: `{"dry_run": true}`, wantStatus: http.StatusBadRequest},
+	}
+	stores := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store { return NewMemoryStore() },
+		"file":   func(t *testing.T) Store { return newTestFileStore(t, 0) },
+	}
+	for storeName, newStore := range stores {
+		for _, tt := range tests {
+			t.Run(storeName+"/"+tt.name, func(t *testing.T) {
+				store := newStore(t)
+				for code, longURL := range seed {
+					mustAdd(t, store, code, longURL)
+				}
+				add := newTestAddPath(store)
+				add.httpsOnly = tt.httpsOnly
+				if tt.allow != "" {
+					add.allowPatterns.Set(tt.allow)
+				}
+				rec := do(&RewritePath{store: store, add: add}, "POST", "/admin/rewrite", tt.body)
+				if rec.Code != tt.wantStatus {
+					t.Fatalf("status = %v, want %v: %s", rec.Code, tt.wantStatus, rec.Body)
+				}
+				if rec.Code == http.StatusOK {
+					var resp struct {
+						Changed int             `json:"changed"`
+						Changes []rewriteChange `json:"changes"`
+					}
+					json.Unmarshal(rec.Body.Bytes(), &resp)
+					if resp.Changed != tt.wantChanged || len(resp.Changes) != tt.wantChanged {
+						t.Errorf("changed = %v with %v changes, want %v", resp.Changed, len(resp.Changes), tt.wantChanged)
+					}
+				}
+				for code, longURL := range seed {
+					want, ok := tt.want[code]
+					if !ok {
+						want = longURL
+					}
+					link, err := store.Get(code)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if link.URL != want {
+						t.Errorf("%v = %q, want %q", code, link.URL, want)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestRewritePathUnsupportedStore(t *testing.T) {
+	p := &RewritePath{store: downStore{}, add: newTestAddPath(downStore{})}
+	rec := do(p, "POST", "/admin/rewrite", `{"from_host": "a", "to_host": "b"}`)
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("status = %v, want %v", rec.Code, http.StatusNotImplemented)
+	}
+}
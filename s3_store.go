@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// s3Client is the part of *s3.Client that S3Store uses, so tests can
+// stand in for a bucket.
+type s3Client interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// maxS3Attempts bounds how often a write is retried after another instance
+// changed the object between our read and our write.
+const maxS3Attempts = 5
+
+// s3Timeout bounds each read or write of the object.
+const s3Timeout = 10 * time.Second
+
+// S3Store keeps the same JSON document as FileStore in one S3 object, for
+// deployments without a persistent disk. Every write reads the object,
+// applies the change and puts it back only if its ETag is unchanged, so
+// instances sharing the object do not lose each other's updates.
+type S3Store struct {
+	client s3Client
+	bucket string
+	key    string
+	// mu serializes this instance's writes; conditional puts handle the
+	// others.
+	mu sync.Mutex
+}
+
+// errUnchanged stops update without writing when fn had nothing to change.
+var errUnchanged = errors.New("nothing to write")
+
+// load reads the object and its ETag. A missing object is an empty store
+// with a nil ETag.
+func (s *S3Store) load() (internalStore, *string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(s.key)})
+	var noKey *types.NoSuchKey
+	if errors.As(err, &noKey) {
+		return internalStore{Version: storeVersion, Items: make(map[string]Link)}, nil, nil
+	}
+	if err != nil {
+		return internalStore{}, nil, fmt.Errorf("unable to read s3://%v/%v: %w", s.bucket, s.key, err)
+	}
+	defer out.Body.Close()
+	raw, err := io.ReadAll(out.Body)
+	if err != nil {
+		return internalStore{}, nil, fmt.Errorf("unable to read s3://%v/%v: %w", s.bucket, s.key, err)
+	}
+	is, err := parseStore(raw)
+	if err != nil {
+		return internalStore{}, nil, err
+	}
+	return is, out.ETag, nil
+}
+
+// update applies fn to the current contents and writes them back,
+// starting over when another writer got there first. fn may run more than
+// once, and when it returns an error (or errUnchanged) nothing is written.
+func (s *S3Store) update(fn func(is *internalStore) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for attempt := 0; attempt < maxS3Attempts; attempt++ {
+		is, etag, err := s.load()
+		if err != nil {
+			return err
+		}
+		is.dropExpired(time.Now())
+		err = fn(&is)
+		if errors.Is(err, errUnchanged) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		raw, err := json.Marshal(is)
+		if err != nil {
+			return fmt.Errorf("unable to generate JSON representation for s3")
+		}
+		in := &s3.PutObjectInput{
+			Bucket:      aws.String(s.bucket),
+			Key:         aws.String(s.key),
+			Body:        bytes.NewReader(raw),
+			ContentType: aws.String("application/json"),
+		}
+		if etag != nil {
+			in.IfMatch = etag
+		} else {
+			in.IfNoneMatch = aws.String("*")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+		_, err = s.client.PutObject(ctx, in)
+		cancel()
+		if !s3Conflict(err) {
+			if err != nil {
+				return fmt.Errorf("unable to write s3://%v/%v: %w", s.bucket, s.key, err)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: s3://%v/%v kept changing under writes from other instances", ErrStoreBusy, s.bucket, s.key)
+}
+
+// s3Conflict reports whether err is S3 refusing a conditional put because
+// the object changed since it was read.
+func s3Conflict(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "PreconditionFailed", "ConditionalRequestConflict":
+		return true
+	}
+	return false
+}
+
+func (s *S3Store) Add(shortenedURL string, link Link) error {
+	return s.update(func(is *internalStore) error {
+		_, ok := is.Items[shortenedURL]
+		if ok {
+			return ErrAlreadyExists
+		}
+		is.Items[shortenedURL] = link
+		delete(is.Removed, shortenedURL)
+		return nil
+	})
+}
+
+func (s *S3Store) Remove(shortenedURL string) error {
+	return s.update(func(is *internalStore) error {
+		_, ok := is.Items[shortenedURL]
+		if !ok {
+			return ErrNotFound
+		}
+		delete(is.Items, shortenedURL)
+		if is.Removed == nil {
+			is.Removed = make(map[string]bool)
+		}
+		is.Removed[shortenedURL] = true
+		return nil
+	})
+}
+
+func (s *S3Store) Get(shortenedURL string) (Link, error) {
+	is, _, err := s.load()
+	if err != nil {
+		return Link{}, err
+	}
+	link, ok := is.Items[shortenedURL]
+	if !ok || link.Expired(time.Now()) {
+		return Link{}, ErrNotFound
+	}
+	return link, nil
+}
+
+func (s *S3Store) GetByURL(longURL string) ([]string, error) {
+	is, _, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	is.dropExpired(time.Now())
+	var codes []string
+	for code, link := range is.Items {
+		if link.URL == longURL {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+	return codes, nil
+}
+
+func (s *S3Store) List(limit, offset int) ([]LinkRecord, error) {
+	is, _, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return listPage(is.Items, time.Now(), limit, offset), nil
+}
+
+func (s *S3Store) ListTagged(tags []string, all bool, limit, offset int) ([]LinkRecord, error) {
+	is, _, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return listPage(tagged(is.Items, tags, all), time.Now(), limit, offset), nil
+}
+
+func (s *S3Store) TagCounts() (map[string]int, error) {
+	is, _, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return countTags(is.Items, time.Now()), nil
+}
+
+func (s *S3Store) WasRemoved(shortenedURL string) (bool, error) {
+	is, _, err := s.load()
+	if err != nil {
+		return false, err
+	}
+	return is.Removed[shortenedURL], nil
+}
+
+// Hit rewrites the object, so every redirect costs a read and a
+// conditional write.
+func (s *S3Store) Hit(shortenedURL string) error {
+	return s.update(func(is *internalStore) error {
+		link, ok := is.Items[shortenedURL]
+		if !ok {
+			return ErrNotFound
+		}
+		link.Hits++
+		is.Items[shortenedURL] = link
+		return nil
+	})
+}
+
+// Rewrite applies fn to every entry and saves the result in a single
+// conditional write, so either all changes land or none do.
+func (s *S3Store) Rewrite(fn func(code, longURL string) (string, bool, error), dryRun bool) ([]rewriteChange, error) {
+	if dryRun {
+		is, _, err := s.load()
+		if err != nil {
+			return nil, err
+		}
+		is.dropExpired(time.Now())
+		return rewriteItems(is.Items, fn)
+	}
+	var changes []rewriteChange
+	err := s.update(func(is *internalStore) error {
+		var err error
+		changes, err = rewriteItems(is.Items, fn)
+		if err == nil && len(changes) == 0 {
+			return errUnchanged
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// rewriteItems applies fn to every link in items, returning the changes
+// sorted by code.
+func rewriteItems(items map[string]Link, fn func(code, longURL string) (string, bool, error)) ([]rewriteChange, error) {
+	var changes []rewriteChange
+	for code, link := range items {
+		rewritten, ok, err := fn(code, link.URL)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		changes = append(changes, rewriteChange{Code: code, From: link.URL, To: rewritten})
+		link.URL = rewritten
+		items[code] = link
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Code < changes[j].Code })
+	return changes, nil
+}
+
+func (s *S3Store) Len() (int, error) {
+	is, _, err := s.load()
+	if err != nil {
+		return 0, err
+	}
+	is.dropExpired(time.Now())
+	return len(is.Items), nil
+}
+
+// NewS3Store keeps links in the object key of bucket, using the default
+// AWS credential chain. The object is read once up front so a missing
+// bucket, missing permissions or corrupt contents fail at startup; a
+// missing object is created on the first write.
+func NewS3Store(bucket, key, region string) (*S3Store, error) {
+	cfg, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load AWS configuration: %v", err)
+	}
+	return newS3Store(s3.NewFromConfig(cfg), bucket, key)
+}
+
+func newS3Store(client s3Client, bucket, key string) (*S3Store, error) {
+	s := &S3Store{client: client, bucket: bucket, key: key}
+	_, _, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// fakeS3 holds a single object and honours If-Match and If-None-Match on
+// PutObject the way S3 does.
+type fakeS3 struct {
+	mu      sync.Mutex
+	data    []byte
+	etag    string
+	version int
+	puts    int
+	// beforePut, when set, runs before each put is checked, outside mu.
+	beforePut func()
+}
+
+func (f *fakeS3) GetObject(ctx context.Context, in *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.etag == "" {
+		return nil, &types.NoSuchKey{}
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(f.data)), ETag: aws.String(f.etag)}, nil
+}
+
+func (f *fakeS3) PutObject(ctx context.Context, in *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	if f.beforePut != nil {
+		f.beforePut()
+	}
+	raw, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.puts++
+	if in.IfMatch != nil && *in.IfMatch != f.etag || in.IfNoneMatch != nil && f.etag != "" {
+		return nil, &smithy.GenericAPIError{Code: "PreconditionFailed", Message: "At least one of the pre-conditions you specified did not hold"}
+	}
+	f.version++
+	f.data = raw
+	f.etag = fmt.Sprintf(`"v%v"`, f.version)
+	return &s3.PutObjectOutput{ETag: aws.String(f.etag)}, nil
+}
+
+func newTestS3Store(t *testing.T, client *fakeS3) *S3Store {
+	t.Helper()
+	s, err := newS3Store(client, "bucket", "store.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestS3Store(t *testing.T) {
+	s := newTestS3Store(t, &fakeS3{})
+	if _, err := s.Get("abc"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("empty store: error = %v, want %v", err, ErrNotFound)
+	}
+	mustAdd(t, s, "abc", "https://example.com/")
+	if err := s.Add("abc", Link{URL: "https://example.org/"}); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("duplicate add: error = %v, want %v", err, ErrAlreadyExists)
+	}
+	if err := s.Hit("abc"); err != nil {
+		t.Fatal(err)
+	}
+	link, err := s.Get("abc")
+	if err != nil || link.URL != "https://example.com/" || link.Hits != 1 {
+		t.Errorf("Get = %+v, %v", link, err)
+	}
+	if codes, err := s.GetByURL("https://example.com/"); err != nil || len(codes) != 1 || codes[0] != "abc" {
+		t.Errorf("GetByURL = %v, %v", codes, err)
+	}
+	if err := s.Remove("abc"); err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := s.WasRemoved("abc"); err != nil || !removed {
+		t.Errorf("WasRemoved = %v, %v", removed, err)
+	}
+	if err := s.Remove("abc"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second remove: error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestS3StoreConflictRetry(t *testing.T) {
+	client := &fakeS3{}
+	s := newTestS3Store(t, client)
+	other := newTestS3Store(t, client)
+	mustAdd(t, s, "first", "https://example.com/1")
+
+	// Another instance writes between this one's read and its put, once.
+	raced := false
+	client.beforePut = func() {
+		if raced {
+			return
+		}
+		raced = true
+		client.beforePut = nil
+		mustAdd(t, other, "other", "https://example.com/other")
+	}
+	putsBefore := client.puts
+	mustAdd(t, s, "mine", "https://example.com/mine")
+
+	if got := client.puts - putsBefore; got != 3 {
+		t.Errorf("%v puts, want the other instance's, the rejected one and the retry", got)
+	}
+	for _, code := range []string{"first", "other", "mine"} {
+		if _, err := s.Get(code); err != nil {
+			t.Errorf("Get(%q): %v, the update was lost", code, err)
+		}
+	}
+}
+
+func TestS3StoreConflictGivesUp(t *testing.T) {
+	client := &fakeS3{}
+	s := newTestS3Store(t, client)
+	other := newTestS3Store(t, client)
+	mustAdd(t, s, "first", "https://example.com/1")
+
+	n := 0
+	var race func()
+	race = func() {
+		client.beforePut = nil
+		n++
+		mustAdd(t, other, fmt.Sprintf("other%v", n), "https://example.com/other")
+		client.beforePut = race
+	}
+	client.beforePut = race
+	err := s.Add("mine", Link{URL: "https://example.com/mine"})
+	client.beforePut = nil
+	if !errors.Is(err, ErrStoreBusy) {
+		t.Fatalf("error = %v, want %v", err, ErrStoreBusy)
+	}
+	if n != maxS3Attempts {
+		t.Errorf("tried %v times, want %v", n, maxS3Attempts)
+	}
+	if _, err := s.Get("mine"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(mine): error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestS3StoreConcurrentInstances(t *testing.T) {
+	client := &fakeS3{}
+	instances := []*S3Store{newTestS3Store(t, client), newTestS3Store(t, client)}
+	const perInstance = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, len(instances)*perInstance)
+	for i, s := range instances {
+		for j := 0; j < perInstance; j++ {
+			wg.Add(1)
+			go func(s *S3Store, code string) {
+				defer wg.Done()
+				// Retry what the store gave up on, as a client would
+				// after a 503.
+				for {
+					err := s.Add(code, Link{URL: "https://example.com/" + code})
+					if !errors.Is(err, ErrStoreBusy) {
+						errs <- err
+						return
+					}
+				}
+			}(s, fmt.Sprintf("c%v-%v", i, j))
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if n, err := instances[0].Len(); err != nil || n != len(instances)*perInstance {
+		t.Errorf("Len = %v, %v, want %v", n, err, len(instances)*perInstance)
+	}
+}
+
+func TestS3StoreRewrite(t *testing.T) {
+	client := &fakeS3{}
+	s := newTestS3Store(t, client)
+	mustAdd(t, s, "a", "http://old.example/a")
+	mustAdd(t, s, "b", "https://other.example/b")
+	puts := client.puts
+
+	fn := rewriteHost("old.example", "new.example")
+	checked := func(code, longURL string) (string, bool, error) {
+		rewritten, ok := fn(code, longURL)
+		return rewritten, ok, nil
+	}
+	changes, err := s.Rewrite(checked, true)
+	if err != nil || len(changes) != 1 || client.puts != puts {
+		t.Fatalf("dry run = %+v, %v with %v puts", changes, err, client.puts-puts)
+	}
+	changes, err = s.Rewrite(checked, false)
+	if err != nil || len(changes) != 1 || changes[0].To != "http://new.example/a" {
+		t.Fatalf("Rewrite = %+v, %v", changes, err)
+	}
+	if link, _ := s.Get("a"); link.URL != "http://new.example/a" {
+		t.Errorf("stored %q", link.URL)
+	}
+	puts = client.puts
+	if changes, err := s.Rewrite(checked, false); err != nil || len(changes) != 0 || client.puts != puts {
+		t.Errorf("no-op rewrite = %+v, %v with %v puts", changes, err, client.puts-puts)
+	}
+}
+
+func TestNewS3StoreRejectsCorruptObject(t *testing.T) {
+	client := &fakeS3{data: []byte("{not json"), etag: `"v1"`}
+	if _, err := newS3Store(client, "bucket", "store.json"); err == nil {
+		t.Errorf("corrupt object accepted")
+	}
+}
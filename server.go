@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// serve runs srv until the process is told to stop. On SIGTERM the server
+// first reports not-ready on /healthz and keeps serving for grace, giving
+// load balancers time to deregister it, before shutting down. SIGINT skips
+// the grace period. In-flight requests get up to timeout to complete.
+func serve(srv *http.Server, readiness *Readiness, grace, timeout time.Duration) error {
+	// Registered before anything can report the server ready, so a
+	// SIGTERM sent as soon as it is up still drains instead of killing it.
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(sig)
+
+	readiness.Set("server", true, "serving")
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case s := <-sig:
+		readiness.Set("server", false, "draining")
+		if s == syscall.SIGTERM && grace > 0 {
+			log.Printf("received %v, draining for %v before shutdown", s, grace)
+			time.Sleep(grace)
+		}
+	}
+
+	log.Print("shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := srv.Shutdown(ctx)
+	if err != nil {
+		return err
+	}
+	err = <-errc
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// healthStatus returns the /healthz status code, or 0 when the server
+// cannot be reached.
+func healthStatus(addr string) int {
+	resp, err := http.Get("http://" + addr + "/healthz")
+	if err != nil {
+		return 0
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestServeDrain(t *testing.T) {
+	tests := []struct {
+		name   string
+		signal syscall.Signal
+		grace  time.Duration
+		// wantDraining is whether /healthz must answer 503 for the grace
+		// period while the server is still accepting requests; otherwise
+		// it must shut down without waiting.
+		wantDraining bool
+	}{
+		{name: "SIGTERM reports not-ready before shutdown", signal: syscall.SIGTERM, grace: 500 * time.Millisecond, wantDraining: true},
+		{name: "SIGINT shuts down without a grace period", signal: syscall.SIGINT, grace: 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := freeAddr(t)
+			readiness := NewReadiness()
+			mux := http.NewServeMux()
+			mux.Handle("/healthz", readiness)
+			srv := &http.Server{Addr: addr, Handler: mux}
+
+			done := make(chan error, 1)
+			go func() { done <- serve(srv, readiness, tt.grace, time.Second) }()
+
+			deadline := time.Now().Add(2 * time.Second)
+			for healthStatus(addr) != http.StatusOK {
+				if time.Now().After(deadline) {
+					t.Fatal("server never became ready")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			start := time.Now()
+			syscall.Kill(os.Getpid(), tt.signal)
+
+			sawDraining := false
+			for {
+				status := healthStatus(addr)
+				if status == http.StatusServiceUnavailable {
+					sawDraining = true
+				}
+				if status == 0 {
+					break
+				}
+				if time.Since(start) > 3*time.Second {
+					t.Fatal("server did not shut down")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if err := <-done; err != nil {
+				t.Fatalf("serve: %v", err)
+			}
+			elapsed := time.Since(start)
+			if tt.wantDraining {
+				if !sawDraining {
+					t.Errorf("/healthz never answered 503 before shutdown")
+				}
+				if elapsed < tt.grace {
+					t.Errorf("shut down after %v, before the %v grace period", elapsed, tt.grace)
+				}
+			} else if elapsed >= tt.grace {
+				t.Errorf("shut down after %v, want no grace period", elapsed)
+			}
+		})
+	}
+}
+
+// A signal sent the moment the server reports ready must reach serve
+// rather than the default handler, which would kill the test binary.
+func TestServeSignalAsSoonAsReady(t *testing.T) {
+	readiness := NewReadiness()
+	readiness.Register("server")
+	srv := &http.Server{Addr: freeAddr(t), Handler: readiness}
+
+	done := make(chan error, 1)
+	go func() { done <- serve(srv, readiness, 0, time.Second) }()
+	deadline := time.Now().Add(2 * time.Second)
+	for !readiness.Ready() {
+		if time.Now().After(deadline) {
+			t.Fatal("server never became ready")
+		}
+	}
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("serve: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	// Registers the pure Go "sqlite" driver, so -store sql works without
+	// cgo or a separate database server.
+	_ "modernc.org/sqlite"
+)
+
+// SQLStore keeps links in a database/sql table. SQLite is linked in as
+// "sqlite"; other drivers (e.g. Postgres) need a blank import. Queries use
+// "?" placeholders, rewritten to "$n" for Postgres drivers.
+type SQLStore struct {
+	db       *sql.DB
+	postgres bool
+}
+
+const createLinksTable = `CREATE TABLE IF NOT EXISTS links (
+	hash TEXT PRIMARY KEY,
+	long_url TEXT NOT NULL,
+	expires_at BIGINT,
+	hits BIGINT NOT NULL DEFAULT 0
+)`
+
+// linkColumns were added to links after it was first released.
+// NewSQLStore adds any that an existing table lacks.
+var linkColumns = []struct{ name, definition string }{
+	{"interstitial", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"redirect_rate", "DOUBLE PRECISION NOT NULL DEFAULT 0"},
+	{"redirect_burst", "INTEGER NOT NULL DEFAULT 0"},
+}
+
+// createLongURLIndex backs GetByURL, which runs on every add with
+// -duplicates other than allow.
+const createLongURLIndex = `CREATE INDEX IF NOT EXISTS links_long_url ON links (long_url)`
+
+// createRemovedTable holds tombstones for removed codes.
+const createRemovedTable = `CREATE TABLE IF NOT EXISTS removed_links (
+	hash TEXT PRIMARY KEY
+)`
+
+// createTagsTable holds one row per tag on a link.
+const createTagsTable = `CREATE TABLE IF NOT EXISTS link_tags (
+	hash TEXT NOT NULL,
+	tag TEXT NOT NULL,
+	PRIMARY KEY (hash, tag)
+)`
+
+// createTagIndex backs ListTagged and TagCounts.
+const createTagIndex = `CREATE INDEX IF NOT EXISTS link_tags_tag ON link_tags (tag)`
+
+// bind rewrites "?" placeholders for drivers that number them.
+func (s *SQLStore) bind(query string) string {
+	if !s.postgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// expiresAt converts between Link.ExpiresAt and the nullable column,
+// which holds Unix nanoseconds.
+func expiresAt(t time.Time) sql.NullInt64 {
+	if t.IsZero() {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: t.UnixNano(), Valid: true}
+}
+
+// Add replaces an expired row under the same hash inside one transaction.
+// The insert skips a conflicting row instead of failing, so a concurrent
+// Add of the same code reports ErrAlreadyExists rather than a driver error.
+func (s *SQLStore) Add(shortenedURL string, link Link) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UnixNano()
+	_, err = tx.Exec(s.bind(`DELETE FROM links WHERE hash = ? AND expires_at IS NOT NULL AND expires_at <= ?`), shortenedURL, now)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(s.bind(`INSERT INTO links (hash, long_url, expires_at, hits, interstitial, redirect_rate, redirect_burst)
+		VALUES (?, ?, ?, ?, ?, ?, ?) ON CONFLICT (hash) DO NOTHING`),
+		shortenedURL, link.URL, expiresAt(link.ExpiresAt), link.Hits, link.Interstitial, link.RedirectRate, link.RedirectBurst)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrAlreadyExists
+	}
+	_, err = tx.Exec(s.bind(`DELETE FROM removed_links WHERE hash = ?`), shortenedURL)
+	if err != nil {
+		return err
+	}
+	// Tags of an expired link replaced above.
+	_, err = tx.Exec(s.bind(`DELETE FROM link_tags WHERE hash = ?`), shortenedURL)
+	if err != nil {
+		return err
+	}
+	for _, tag := range link.Tags {
+		_, err = tx.Exec(s.bind(`INSERT INTO link_tags (hash, tag) VALUES (?, ?)`), shortenedURL, tag)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Remove deletes the link and records a tombstone for it in one
+// transaction.
+func (s *SQLStore) Remove(shortenedURL string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(s.bind(`DELETE FROM links WHERE hash = ? AND (expires_at IS NULL OR expires_at > ?)`),
+		shortenedURL, time.Now().UnixNano())
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	_, err = tx.Exec(s.bind(`DELETE FROM link_tags WHERE hash = ?`), shortenedURL)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(s.bind(`INSERT INTO removed_links (hash) VALUES (?) ON CONFLICT (hash) DO NOTHING`), shortenedURL)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) WasRemoved(shortenedURL string) (bool, error) {
+	var n int
+	err := s.db.QueryRow(s.bind(`SELECT COUNT(*) FROM removed_links WHERE hash = ?`), shortenedURL).Scan(&n)
+	return n > 0, err
+}
+
+func (s *SQLStore) Get(shortenedURL string) (Link, error) {
+	var link Link
+	var expires sql.NullInt64
+	err := s.db.QueryRow(s.bind(`SELECT long_url, expires_at, hits, interstitial, redirect_rate, redirect_burst FROM links WHERE hash = ?`), shortenedURL).
+		Scan(&link.URL, &expires, &link.Hits, &link.Interstitial, &link.RedirectRate, &link.RedirectBurst)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Link{}, ErrNotFound
+	}
+	if err != nil {
+		return Link{}, err
+	}
+	if expires.Valid {
+		link.ExpiresAt = time.Unix(0, expires.Int64).UTC()
+	}
+	if link.Expired(time.Now()) {
+		return Link{}, ErrNotFound
+	}
+	rows, err := s.db.Query(s.bind(`SELECT tag FROM link_tags WHERE hash = ? ORDER BY tag`), shortenedURL)
+	if err != nil {
+		return Link{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tag string
+		err = rows.Scan(&tag)
+		if err != nil {
+			return Link{}, err
+		}
+		link.Tags = append(link.Tags, tag)
+	}
+	return link, rows.Err()
+}
+
+func (s *SQLStore) GetByURL(longURL string) ([]string, error) {
+	rows, err := s.db.Query(s.bind(`SELECT hash FROM links WHERE long_url = ? AND (expires_at IS NULL OR expires_at > ?)`),
+		longURL, time.Now().UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var codes []string
+	for rows.Next() {
+		var code string
+		err = rows.Scan(&code)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes, rows.Err()
+}
+
+func (s *SQLStore) List(limit, offset int) ([]LinkRecord, error) {
+	return s.listLinks(`SELECT hash, long_url, expires_at, hits, interstitial, redirect_rate, redirect_burst FROM links
+		WHERE expires_at IS NULL OR expires_at > ? ORDER BY hash LIMIT ? OFFSET ?`,
+		time.Now().UnixNano(), limit, offset)
+}
+
+// ListTagged finds the matching hashes through link_tags, counting the
+// tags each one carries when all of them are required.
+func (s *SQLStore) ListTagged(tags []string, all bool, limit, offset int) ([]LinkRecord, error) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(tags)), ", ")
+	tagged := `SELECT hash FROM link_tags WHERE tag IN (` + placeholders + `)`
+	args := make([]interface{}, 0, len(tags)+4)
+	for _, tag := range tags {
+		args = append(args, tag)
+	}
+	if all {
+		tagged += ` GROUP BY hash HAVING COUNT(*) = ?`
+		args = append(args, len(tags))
+	}
+	args = append(args, time.Now().UnixNano(), limit, offset)
+	return s.listLinks(`SELECT hash, long_url, expires_at, hits, interstitial, redirect_rate, redirect_burst FROM links
+		WHERE hash IN (`+tagged+`) AND (expires_at IS NULL OR expires_at > ?) ORDER BY hash LIMIT ? OFFSET ?`,
+		args...)
+}
+
+func (s *SQLStore) TagCounts() (map[string]int, error) {
+	rows, err := s.db.Query(s.bind(`SELECT link_tags.tag, COUNT(*) FROM link_tags
+		JOIN links ON links.hash = link_tags.hash
+		WHERE links.expires_at IS NULL OR links.expires_at > ? GROUP BY link_tags.tag`),
+		time.Now().UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := make(map[string]int)
+	for rows.Next() {
+		var tag string
+		var n int
+		err = rows.Scan(&tag, &n)
+		if err != nil {
+			return nil, err
+		}
+		counts[tag] = n
+	}
+	return counts, rows.Err()
+}
+
+// listLinks runs query, which selects the columns List needs ordered by
+// hash, and fills in the tags of the links it returns.
+func (s *SQLStore) listLinks(query string, args ...interface{}) ([]LinkRecord, error) {
+	rows, err := s.db.Query(s.bind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var records []LinkRecord
+	for rows.Next() {
+		var rec LinkRecord
+		var expires sql.NullInt64
+		err = rows.Scan(&rec.Hash, &rec.URL, &expires, &rec.Hits, &rec.Interstitial, &rec.RedirectRate, &rec.RedirectBurst)
+		if err != nil {
+			return nil, err
+		}
+		if expires.Valid {
+			rec.ExpiresAt = time.Unix(0, expires.Int64).UTC()
+		}
+		records = append(records, rec)
+	}
+	err = rows.Err()
+	if err != nil || len(records) == 0 {
+		return records, err
+	}
+	return records, s.addTags(records)
+}
+
+// addTags fills in the tags of records, which are sorted by hash, with one
+// query over the range of hashes they span.
+func (s *SQLStore) addTags(records []LinkRecord) error {
+	index := make(map[string]int, len(records))
+	for i, rec := range records {
+		index[rec.Hash] = i
+	}
+	rows, err := s.db.Query(s.bind(`SELECT hash, tag FROM link_tags WHERE hash >= ? AND hash <= ? ORDER BY hash, tag`),
+		records[0].Hash, records[len(records)-1].Hash)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hash, tag string
+		err = rows.Scan(&hash, &tag)
+		if err != nil {
+			return err
+		}
+		if i, ok := index[hash]; ok {
+			records[i].Tags = append(records[i].Tags, tag)
+		}
+	}
+	return rows.Err()
+}
+
+// Rewrite reads every live link and applies the changes inside one
+// transaction, so either all of them land or none do.
+func (s *SQLStore) Rewrite(fn func(code, longURL string) (string, bool, error), dryRun bool) ([]rewriteChange, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(s.bind(`SELECT hash, long_url FROM links WHERE expires_at IS NULL OR expires_at > ? ORDER BY hash`),
+		time.Now().UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	var changes []rewriteChange
+	for rows.Next() {
+		var code, longURL string
+		err = rows.Scan(&code, &longURL)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		rewritten, ok, err := fn(code, longURL)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if ok {
+			changes = append(changes, rewriteChange{Code: code, From: longURL, To: rewritten})
+		}
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	if dryRun || len(changes) == 0 {
+		return changes, nil
+	}
+	for _, c := range changes {
+		_, err = tx.Exec(s.bind(`UPDATE links SET long_url = ? WHERE hash = ?`), c.To, c.Code)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return changes, tx.Commit()
+}
+
+func (s *SQLStore) Hit(shortenedURL string) error {
+	res, err := s.db.Exec(s.bind(`UPDATE links SET hits = hits + 1 WHERE hash = ? AND (expires_at IS NULL OR expires_at > ?)`),
+		shortenedURL, time.Now().UnixNano())
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLStore) Len() (int, error) {
+	var n int
+	err := s.db.QueryRow(s.bind(`SELECT COUNT(*) FROM links WHERE expires_at IS NULL OR expires_at > ?`), time.Now().UnixNano()).Scan(&n)
+	return n, err
+}
+
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+// sqliteParams are added to SQLite DSNs that do not set them. Without a
+// busy timeout a write fails at once with SQLITE_BUSY while another
+// process sharing the file holds the lock, instead of waiting for it; WAL
+// lets that process's reads proceed during our writes.
+var sqliteParams = []struct{ key, value, match string }{
+	{"_pragma", "busy_timeout(5000)", "busy_timeout"},
+	{"_pragma", "journal_mode(WAL)", "journal_mode"},
+}
+
+// sqliteDSN adds the sqliteParams missing from dsn.
+func sqliteDSN(dsn string) (string, error) {
+	base, query, _ := strings.Cut(dsn, "?")
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		return "", err
+	}
+	for _, p := range sqliteParams {
+		found := false
+		for _, v := range q[p.key] {
+			if p.match == "" || strings.HasPrefix(strings.ToLower(strings.TrimSpace(v)), p.match) {
+				found = true
+			}
+		}
+		if !found {
+			q.Add(p.key, p.value)
+		}
+	}
+	return base + "?" + q.Encode(), nil
+}
+
+// NewSQLStore opens dsn with the named driver and creates the tables and
+// index it needs if they do not exist. SQLite DSNs get sqliteParams.
+func NewSQLStore(driver, dsn string) (*SQLStore, error) {
+	if driver == "sqlite" {
+		var err error
+		dsn, err = sqliteDSN(dsn)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sqlite data source name: %v", err)
+		}
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %v database: %v", driver, err)
+	}
+	if driver == "sqlite" {
+		// SQLite allows a single writer; queue this process's queries
+		// for one connection rather than having each poll for the lock.
+		db.SetMaxOpenConns(1)
+	}
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to reach %v database: %v", driver, err)
+	}
+	s := &SQLStore{db: db, postgres: driver == "postgres" || driver == "pgx"}
+	for _, stmt := range []string{createLinksTable, createLongURLIndex, createRemovedTable, createTagsTable, createTagIndex} {
+		_, err = db.Exec(stmt)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("unable to create tables: %v", err)
+		}
+	}
+	err = s.addLinkColumns()
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to upgrade the links table: %v", err)
+	}
+	return s, nil
+}
+
+// addLinkColumns adds the linkColumns missing from the links table.
+func (s *SQLStore) addLinkColumns() error {
+	rows, err := s.db.Query(`SELECT * FROM links LIMIT 0`)
+	if err != nil {
+		return err
+	}
+	columns, err := rows.Columns()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+	have := make(map[string]bool)
+	for _, c := range columns {
+		have[strings.ToLower(c)] = true
+	}
+	for _, c := range linkColumns {
+		if have[c.name] {
+			continue
+		}
+		_, err = s.db.Exec(fmt.Sprintf(`ALTER TABLE links ADD COLUMN %v %v`, c.name, c.definition))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestSQLStore(t *testing.T) *SQLStore {
+	t.Helper()
+	// Each connection to a plain :memory: database gets its own empty
+	// database, so share one per test through the cache.
+	dsn := fmt.Sprintf("file:%v?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"))
+	s, err := NewSQLStore("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestSQLStore(t *testing.T) {
+	tests := []struct {
+		name    string
+		run     func(s *SQLStore) error
+		wantErr error
+	}{
+		{
+			name: "add then get",
+			run: func(s *SQLStore) error {
+				err := s.Add("abc", Link{URL: "https://example.com/"})
+				if err != nil {
+					return err
+				}
+				link, err := s.Get("abc")
+				if err != nil {
+					return err
+				}
+				if link.URL != "https://example.com/" {
+					return fmt.Errorf("got %q", link.URL)
+				}
+				return nil
+			},
+		},
+		{
+			name: "duplicate key",
+			run: func(s *SQLStore) error {
+				err := s.Add("abc", Link{URL: "https://example.com/"})
+				if err != nil {
+					return err
+				}
+				return s.Add("abc", Link{URL: "https://example.org/"})
+			},
+			wantErr: ErrAlreadyExists,
+		},
+		{
+			name: "get unknown",
+			run: func(s *SQLStore) error {
+				_, err := s.Get("missing")
+				return err
+			},
+			wantErr: ErrNotFound,
+		},
+		{
+			name: "remove then get",
+			run: func(s *SQLStore) error {
+				err := s.Add("abc", Link{URL: "https://example.com/"})
+				if err != nil {
+					return err
+				}
+				err = s.Remove("abc")
+				if err != nil {
+					return err
+				}
+				_, err = s.Get("abc")
+				return err
+			},
+			wantErr: ErrNotFound,
+		},
+		{
+			name: "remove unknown",
+			run: func(s *SQLStore) error {
+				return s.Remove("missing")
+			},
+			wantErr: ErrNotFound,
+		},
+		{
+			name: "remove leaves a tombstone",
+			run: func(s *SQLStore) error {
+				err := s.Add("abc", Link{URL: "https://example.com/"})
+				if err != nil {
+					return err
+				}
+				err = s.Remove("abc")
+				if err != nil {
+					return err
+				}
+				removed, err := s.WasRemoved("abc")
+				if err != nil {
+					return err
+				}
+				if !removed {
+					return fmt.Errorf("abc is not marked removed")
+				}
+				return nil
+			},
+		},
+		{
+			name: "re-adding clears the tombstone",
+			run: func(s *SQLStore) error {
+				for _, step := range []func() error{
+					func() error { return s.Add("abc", Link{URL: "https://example.com/"}) },
+					func() error { return s.Remove("abc") },
+					func() error { return s.Add("abc", Link{URL: "https://example.org/"}) },
+				} {
+					if err := step(); err != nil {
+						return err
+					}
+				}
+				removed, err := s.WasRemoved("abc")
+				if err != nil {
+					return err
+				}
+				if removed {
+					return fmt.Errorf("abc is still marked removed")
+				}
+				return nil
+			},
+		},
+		{
+			name: "expired code can be reused",
+			run: func(s *SQLStore) error {
+				err := s.Add("abc", Link{URL: "https://example.com/", ExpiresAt: time.Now().Add(-time.Second)})
+				if err != nil {
+					return err
+				}
+				return s.Add("abc", Link{URL: "https://example.org/"})
+			},
+		},
+		{
+			name: "get by url",
+			run: func(s *SQLStore) error {
+				for _, code := range []string{"b", "a"} {
+					if err := s.Add(code, Link{URL: "https://example.com/"}); err != nil {
+						return err
+					}
+				}
+				codes, err := s.GetByURL("https://example.com/")
+				if err != nil {
+					return err
+				}
+				if strings.Join(codes, ",") != "a,b" {
+					return fmt.Errorf("got %v", codes)
+				}
+				return nil
+			},
+		},
+		{
+			name: "hit counts",
+			run: func(s *SQLStore) error {
+				err := s.Add("abc", Link{URL: "https://example.com/"})
+				if err != nil {
+					return err
+				}
+				for i := 0; i < 3; i++ {
+					if err := s.Hit("abc"); err != nil {
+						return err
+					}
+				}
+				link, err := s.Get("abc")
+				if err != nil {
+					return err
+				}
+				if link.Hits != 3 {
+					return fmt.Errorf("hits = %v, want 3", link.Hits)
+				}
+				return nil
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.run(newTestSQLStore(t))
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSQLStoreRewrite(t *testing.T) {
+	tests := []struct {
+		name    string
+		dryRun  bool
+		fail    bool
+		wantURL string
+	}{
+		{name: "apply", wantURL: "https://new.example.com/a"},
+		{name: "dry run", dryRun: true, wantURL: "https://old.example.com/a"},
+		{name: "failed rewrite changes nothing", fail: true, wantURL: "https://old.example.com/a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSQLStore(t)
+			s.Add("a", Link{URL: "https://old.example.com/a"})
+			s.Add("b", Link{URL: "https://old.example.com/b"})
+			changes, err := s.Rewrite(func(code, longURL string) (string, bool, error) {
+				if tt.fail && code == "b" {
+					return "", false, errors.New("rejected")
+				}
+				return strings.Replace(longURL, "old.", "new.", 1), true, nil
+			}, tt.dryRun)
+			if tt.fail != (err != nil) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tt.fail && len(changes) != 2 {
+				t.Errorf("got %v changes, want 2", len(changes))
+			}
+			link, err := s.Get("a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if link.URL != tt.wantURL {
+				t.Errorf("url = %q, want %q", link.URL, tt.wantURL)
+			}
+		})
+	}
+}
+
+func TestSQLStoreConcurrentAddSameCode(t *testing.T) {
+	s := newTestSQLStore(t)
+	const n = 10
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = s.Add("abc", Link{URL: fmt.Sprintf("https://example.com/%v", i)})
+		}(i)
+	}
+	wg.Wait()
+	added := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			added++
+		case errors.Is(err, ErrAlreadyExists):
+		default:
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if added != 1 {
+		t.Errorf("%v adds succeeded, want 1", added)
+	}
+}
+
+func TestSQLStoreConcurrentAdds(t *testing.T) {
+	tests := []struct {
+		name string
+		dsn  func(t *testing.T) string
+	}{
+		{name: "shared cache", dsn: func(t *testing.T) string {
+			return fmt.Sprintf("file:%v?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"))
+		}},
+		{name: "file", dsn: func(t *testing.T) string { return filepath.Join(t.TempDir(), "links.db") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewSQLStore("sqlite", tt.dsn(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			mustAdd(t, s, "abc", "https://example.com/")
+			// Lock contention needs the writers running in parallel.
+			defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+			const n = 50
+			errs := make([]error, n)
+			start := make(chan struct{})
+			var wg sync.WaitGroup
+			for i := 0; i < n; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					<-start
+					errs[i] = s.Add(fmt.Sprintf("c%v", i), Link{URL: fmt.Sprintf("https://example.com/%v", i)})
+					if errs[i] == nil {
+						errs[i] = s.Hit("abc")
+					}
+				}(i)
+			}
+			close(start)
+			wg.Wait()
+			for i, err := range errs {
+				if err != nil {
+					t.Errorf("add %v: %v", i, err)
+				}
+			}
+			if got, err := s.Len(); err != nil || got != n+1 {
+				t.Errorf("Len = %v, %v, want %v", got, err, n+1)
+			}
+			if link, err := s.Get("abc"); err != nil || link.Hits != n {
+				t.Errorf("Get = %+v, %v, want %v hits", link, err, n)
+			}
+		})
+	}
+}
+
+func TestSQLiteDSN(t *testing.T) {
+	tests := []struct {
+		dsn  string
+		want string
+	}{
+		{dsn: "links.db", want: "links.db?_pragma=busy_timeout%285000%29&_pragma=journal_mode%28WAL%29"},
+		{
+			dsn:  "file:links?mode=memory&cache=shared",
+			want: "file:links?_pragma=busy_timeout%285000%29&_pragma=journal_mode%28WAL%29&cache=shared&mode=memory",
+		},
+		{
+			dsn:  "links.db?_pragma=busy_timeout(100)&_pragma=JOURNAL_MODE(DELETE)",
+			want: "links.db?_pragma=busy_timeout%28100%29&_pragma=JOURNAL_MODE%28DELETE%29",
+		},
+	}
+	for _, tt := range tests {
+		got, err := sqliteDSN(tt.dsn)
+		if err != nil || got != tt.want {
+			t.Errorf("sqliteDSN(%q) = %q, %v, want %q", tt.dsn, got, err, tt.want)
+		}
+	}
+}
+
+func TestNewSQLStoreAddsColumns(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "links.db")
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The links table as first released, before linkColumns.
+	_, err = db.Exec(`CREATE TABLE links (
+		hash TEXT PRIMARY KEY,
+		long_url TEXT NOT NULL,
+		expires_at BIGINT,
+		hits BIGINT NOT NULL DEFAULT 0
+	)`)
+	if err == nil {
+		_, err = db.Exec(`INSERT INTO links (hash, long_url) VALUES ('old', 'https://example.com/')`)
+	}
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewSQLStore("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	link, err := s.Get("old")
+	if err != nil || !reflect.DeepEqual(link, Link{URL: "https://example.com/"}) {
+		t.Errorf("old link = %+v, %v", link, err)
+	}
+	want := Link{URL: "https://example.org/", Interstitial: true, RedirectRate: 2.5, RedirectBurst: 5}
+	mustAdd(t, s, "new", want.URL)
+	if err := s.Add("flagged", want); err != nil {
+		t.Fatal(err)
+	}
+	if link, err := s.Get("flagged"); err != nil || !reflect.DeepEqual(link, want) {
+		t.Errorf("flagged link = %+v, %v, want %+v", link, err, want)
+	}
+}
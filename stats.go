@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// HitCounter is implemented by stores that keep a click count on each
+// link. Hit fails for codes that do not exist or have expired.
+type HitCounter interface {
+	Hit(shortenedURL string) error
+}
+
+// StatsPath reports a link and its click count without redirecting.
+type StatsPath struct {
+	domain string
+	store  Store
+}
+
+func (p *StatsPath) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hash := mux.Vars(r)["hash"]
+	link, err := p.store.Get(hash)
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrInvalidAlias) {
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("unexpected error: %v", err))
+		return
+	}
+
+	type statsResponse struct {
+		ShortenedURL string `json:"shortened_url"`
+		LongURL      string `json:"long_url"`
+		Clicks       int64  `json:"clicks"`
+	}
+	writeJSON(w, http.StatusOK, statsResponse{
+		ShortenedURL: shortURL(p.domain, hash),
+		LongURL:      link.URL,
+		Clicks:       link.Hits,
+	})
+}
+
+// countHit bumps the click count for hash. A failure is logged rather than
+// holding up the redirect.
+func (p *RedirectPath) countHit(hash string) {
+	hc, ok := p.store.(HitCounter)
+	if !ok {
+		return
+	}
+	err := hc.Hit(hash)
+	if err != nil {
+		log.Printf("unable to count hit on %v: %v", hash, err)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStatsPath(t *testing.T) {
+	stores := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store { return NewMemoryStore() },
+		"file":   func(t *testing.T) Store { return newTestFileStore(t, 0) },
+		"sql":    func(t *testing.T) Store { return newTestSQLStore(t) },
+	}
+	tests := []struct {
+		name       string
+		hash       string
+		redirects  int
+		wantStatus int
+	}{
+		{name: "never followed", hash: "abc", wantStatus: http.StatusOK},
+		{name: "followed", hash: "abc", redirects: 5, wantStatus: http.StatusOK},
+		{name: "unknown", hash: "missing", wantStatus: http.StatusNotFound},
+	}
+	for storeName, newStore := range stores {
+		for _, tt := range tests {
+			t.Run(storeName+"/"+tt.name, func(t *testing.T) {
+				store := newStore(t)
+				mustAdd(t, store, "abc", "https://example.com/")
+				redirect := &RedirectPath{store: store, redirectStatus: http.StatusFound}
+				for i := 0; i < tt.redirects; i++ {
+					if rec := doHash(redirect, "GET", "abc", ""); rec.Code != http.StatusFound {
+						t.Fatalf("redirect status = %v", rec.Code)
+					}
+				}
+				stats := &StatsPath{domain: "sho.rt", store: store}
+				// Looking at the stats must not count as a click.
+				doHash(stats, "GET", tt.hash, "")
+				rec := doHash(stats, "GET", tt.hash, "")
+				if rec.Code != tt.wantStatus {
+					t.Fatalf("status = %v, want %v: %s", rec.Code, tt.wantStatus, rec.Body)
+				}
+				if rec.Code != http.StatusOK {
+					return
+				}
+				resp := decode(t, rec)
+				if resp["clicks"] != float64(tt.redirects) {
+					t.Errorf("clicks = %v, want %v", resp["clicks"], tt.redirects)
+				}
+				if resp["long_url"] != "https://example.com/" || resp["shortened_url"] != "https://sho.rt/abc" {
+					t.Errorf("unexpected response %v", resp)
+				}
+			})
+		}
+	}
+}
+
+func TestStatsPathStoreFailure(t *testing.T) {
+	rec := doHash(&StatsPath{domain: "sho.rt", store: downStore{}}, "GET", "abc", "")
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %v, want %v", rec.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestFileStoreHitsBatched(t *testing.T) {
+	// Not newTestFileStore: the test closes the store itself.
+	fs, err := NewFileStore(filepath.Join(t.TempDir(), "store.json"), 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustAdd(t, fs, "abc", "https://example.com/")
+	before, err := os.ReadFile(fs.filenane)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 100
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fs.Hit("abc")
+		}()
+	}
+	wg.Wait()
+
+	after, _ := os.ReadFile(fs.filenane)
+	if !bytes.Equal(before, after) {
+		t.Error("hits rewrote the file before the flush")
+	}
+	link, err := fs.Get("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Hits != n {
+		t.Errorf("pending hits = %v, want %v", link.Hits, n)
+	}
+
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewFileStore(fs.filenane, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	link, err = reopened.Get("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Hits != n {
+		t.Errorf("saved hits = %v, want %v", link.Hits, n)
+	}
+}
+
+func TestFileStoreHitsFlushPeriodically(t *testing.T) {
+	fs, err := NewFileStore(filepath.Join(t.TempDir(), "store.json"), 0, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	mustAdd(t, fs, "abc", "https://example.com/")
+	fs.Hit("abc")
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		raw, _ := os.ReadFile(fs.filenane)
+		is, err := parseStore(raw)
+		if err == nil && is.Items["abc"].Hits == 1 {
+			return
+		}
+	}
+	t.Error("click count never reached the file")
+}
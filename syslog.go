@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// syslogPriority is facility local0 with severity informational.
+	syslogPriority = 16*8 + 6
+	syslogAppName  = "url-shortener"
+	// syslogSDID identifies our structured data element; 32473 is the
+	// private enterprise number reserved for documentation.
+	syslogSDID = "audit@32473"
+)
+
+// SyslogHook sends link events to a syslog collector as RFC 5424 messages.
+// Messages are queued and written by a single goroutine; when the collector
+// is unreachable or the queue is full, messages are dropped rather than
+// holding up requests.
+type SyslogHook struct {
+	network  string
+	address  string
+	hostname string
+	queue    chan string
+}
+
+func (h *SyslogHook) OnAdd(e Event)      { h.enqueue("created", e) }
+func (h *SyslogHook) OnRemove(e Event)   { h.enqueue("deleted", e) }
+func (h *SyslogHook) OnRedirect(e Event) { h.enqueue("redirected", e) }
+func (h *SyslogHook) OnUpdate(e Event)   { h.enqueue("updated", e) }
+
+func (h *SyslogHook) enqueue(msgID string, e Event) {
+	select {
+	case h.queue <- h.format(msgID, e):
+	default:
+		log.Printf("syslog: queue full, dropping %v event for %v", msgID, e.Code)
+	}
+}
+
+func (h *SyslogHook) format(msgID string, e Event) string {
+	sd := fmt.Sprintf(`[%v code="%v" requestId="%v" remoteAddr="%v"]`,
+		syslogSDID, sdEscape(e.Code), sdEscape(e.RequestID), sdEscape(e.RemoteAddr))
+	return fmt.Sprintf("<%d>1 %v %v %v %d %v %v link %v %v",
+		syslogPriority, e.Time.UTC().Format(time.RFC3339Nano), h.hostname, syslogAppName,
+		os.Getpid(), msgID, sd, e.Code, msgID)
+}
+
+// sdEscape escapes a structured data parameter value as RFC 5424 requires.
+func sdEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(s)
+}
+
+func (h *SyslogHook) run() {
+	var conn net.Conn
+	for msg := range h.queue {
+		if conn == nil {
+			var err error
+			conn, err = net.DialTimeout(h.network, h.address, 5*time.Second)
+			if err != nil {
+				log.Printf("syslog: unable to connect to %v: %v", h.address, err)
+				continue
+			}
+		}
+		if h.network == "tcp" {
+			// Octet-counting framing from RFC 6587.
+			msg = fmt.Sprintf("%d %v", len(msg), msg)
+		}
+		conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		_, err := conn.Write([]byte(msg))
+		if err != nil {
+			log.Printf("syslog: unable to write to %v: %v", h.address, err)
+			conn.Close()
+			conn = nil
+		}
+	}
+}
+
+// NewSyslogHook sends events to target, which is udp://host:port,
+// tcp://host:port or unixgram:///path/to/socket.
+func NewSyslogHook(target string) (*SyslogHook, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	h := &SyslogHook{network: u.Scheme, address: u.Host, queue: make(chan string, 1024)}
+	switch u.Scheme {
+	case "udp", "tcp":
+	case "unixgram":
+		h.address = u.Path
+	default:
+		return nil, fmt.Errorf("unsupported syslog target %q", target)
+	}
+	h.hostname, err = os.Hostname()
+	if err != nil {
+		h.hostname = "-"
+	}
+	go h.run()
+	return h, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// syslogLine matches the header and structured data SyslogHook writes.
+var syslogLine = regexp.MustCompile(`^<134>1 \S+ \S+ url-shortener \d+ (\S+) \[audit@32473 code="((?:[^"\\]|\\.)*)" requestId="((?:[^"\\]|\\.)*)" remoteAddr="((?:[^"\\]|\\.)*)"\] link `)
+
+// listenSyslog starts a stub collector on network and returns its address
+// and a channel of the messages it receives, with TCP framing removed.
+func listenSyslog(t *testing.T, network string) (string, <-chan string) {
+	t.Helper()
+	msgs := make(chan string, 16)
+	if network == "udp" {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		go func() {
+			buf := make([]byte, 64*1024)
+			for {
+				n, _, err := conn.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+				msgs <- string(buf[:n])
+			}
+		}()
+		return conn.LocalAddr().String(), msgs
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			length, err := r.ReadString(' ')
+			if err != nil {
+				return
+			}
+			n, _ := strconv.Atoi(strings.TrimSpace(length))
+			buf := make([]byte, n)
+			if _, err := r.Read(buf); err != nil {
+				return
+			}
+			msgs <- string(buf)
+		}
+	}()
+	return l.Addr().String(), msgs
+}
+
+func TestSyslogHook(t *testing.T) {
+	e := Event{Code: "abc", LongURL: "https://example.com/", Time: time.Now(), RemoteAddr: "192.0.2.1", RequestID: `req"1]`}
+	tests := []struct {
+		network string
+		send    func(h *SyslogHook)
+		msgID   string
+	}{
+		{network: "udp", send: func(h *SyslogHook) { h.OnAdd(e) }, msgID: "created"},
+		{network: "udp", send: func(h *SyslogHook) { h.OnRedirect(e) }, msgID: "redirected"},
+		{network: "tcp", send: func(h *SyslogHook) { h.OnRemove(e) }, msgID: "deleted"},
+		{network: "tcp", send: func(h *SyslogHook) { h.OnUpdate(e) }, msgID: "updated"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.network+"/"+tt.msgID, func(t *testing.T) {
+			addr, msgs := listenSyslog(t, tt.network)
+			h, err := NewSyslogHook(tt.network + "://" + addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.send(h)
+			var msg string
+			select {
+			case msg = <-msgs:
+			case <-time.After(5 * time.Second):
+				t.Fatal("no message received")
+			}
+			m := syslogLine.FindStringSubmatch(msg)
+			if m == nil {
+				t.Fatalf("malformed message %q", msg)
+			}
+			if m[1] != tt.msgID || m[2] != "abc" || m[3] != `req\"1\]` || m[4] != "192.0.2.1" {
+				t.Errorf("unexpected fields in %q", msg)
+			}
+		})
+	}
+}
+
+func TestSyslogHookUnavailable(t *testing.T) {
+	// Grab a free port and close it again so nothing is listening.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	h, err := NewSyslogHook("tcp://" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for i := 0; i < 2*cap(h.queue); i++ {
+		h.OnRedirect(Event{Code: "abc", Time: time.Now()})
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("sending to an unavailable collector took %v", d)
+	}
+}
+
+func TestNewSyslogHookRejectsUnknownScheme(t *testing.T) {
+	for _, target := range []string{"http://localhost:514", "localhost:514"} {
+		if _, err := NewSyslogHook(target); err == nil {
+			t.Errorf("%v: expected an error", target)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	maxTags      = 20
+	maxTagLength = 64
+)
+
+// TagStore is implemented by stores that can find links by tag. Stores
+// backed by a database answer both from an index on the tag.
+type TagStore interface {
+	// ListTagged is List restricted to links carrying every one of tags
+	// when all is set, or any of them otherwise. tags must not repeat.
+	ListTagged(tags []string, all bool, limit, offset int) ([]LinkRecord, error)
+	// TagCounts returns how many unexpired links carry each tag.
+	TagCounts() (map[string]int, error)
+}
+
+// normalizeTags trims tags, drops duplicates and sorts them, so a link's
+// tags compare equal however they were sent.
+func normalizeTags(tags []string) ([]string, error) {
+	if len(tags) > maxTags {
+		return nil, requestError(fmt.Sprintf("at most %v tags are allowed", maxTags))
+	}
+	seen := make(map[string]bool, len(tags))
+	var out []string
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || len(tag) > maxTagLength {
+			return nil, requestError(fmt.Sprintf("tags must be between 1 and %v bytes", maxTagLength))
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			out = append(out, tag)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// hasTags reports whether link carries all of tags, or any of them when
+// all is false.
+func (l Link) hasTags(tags []string, all bool) bool {
+	for _, want := range tags {
+		found := false
+		for _, tag := range l.Tags {
+			if tag == want {
+				found = true
+				break
+			}
+		}
+		if found != all {
+			return found
+		}
+	}
+	return all
+}
+
+// tagged returns the links in items matching tags as in Link.hasTags.
+func tagged(items map[string]Link, tags []string, all bool) map[string]Link {
+	out := make(map[string]Link)
+	for hash, link := range items {
+		if link.hasTags(tags, all) {
+			out[hash] = link
+		}
+	}
+	return out
+}
+
+// countTags counts the unexpired links in items carrying each tag.
+func countTags(items map[string]Link, now time.Time) map[string]int {
+	counts := make(map[string]int)
+	for _, link := range items {
+		if link.Expired(now) {
+			continue
+		}
+		for _, tag := range link.Tags {
+			counts[tag]++
+		}
+	}
+	return counts
+}
+
+// TagsPath lists every tag in use with the number of links carrying it.
+type TagsPath struct {
+	store Store
+}
+
+func (p *TagsPath) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ts, ok := p.store.(TagStore)
+	if !ok {
+		writeJSONError(w, http.StatusNotImplemented, "the configured store cannot list tags")
+		return
+	}
+	counts, err := ts.TagCounts()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("unexpected error: %v", err))
+		return
+	}
+
+	type tagResponse struct {
+		Tag   string `json:"tag"`
+		Count int    `json:"count"`
+	}
+	resp := make([]tagResponse, 0, len(counts))
+	for tag, n := range counts {
+		resp = append(resp, tagResponse{Tag: tag, Count: n})
+	}
+	sort.Slice(resp, func(i, j int) bool { return resp[i].Tag < resp[j].Tag })
+	writeJSON(w, http.StatusOK, resp)
+}
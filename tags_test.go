@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTags(t *testing.T) {
+	stores := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store { return NewMemoryStore() },
+		"file":   func(t *testing.T) Store { return newTestFileStore(t, 0) },
+		"sql":    func(t *testing.T) Store { return newTestSQLStore(t) },
+		"tiered": func(t *testing.T) Store {
+			return NewTieredStore(NewMemoryStore(), newTestFileStore(t, 0), WriteThrough)
+		},
+	}
+	listTests := []struct {
+		query     string
+		wantCodes []string
+	}{
+		{query: "?tag=go", wantCodes: []string{"a", "b", "c"}},
+		{query: "?tag=go&tag=web", wantCodes: []string{"a", "b"}},
+		{query: "?tag=go&tag=web&match=all", wantCodes: []string{"a", "b"}},
+		{query: "?tag=web&tag=db&tag=go", wantCodes: []string{"b"}},
+		{query: "?tag=db&tag=docs&match=any", wantCodes: []string{"b", "d"}},
+		{query: "?tag=go&tag=web&match=any", wantCodes: []string{"a", "b", "c"}},
+		{query: "?tag=go&tag=go", wantCodes: []string{"a", "b", "c"}},
+		{query: "?tag=go&limit=1&offset=1", wantCodes: []string{"b"}},
+		{query: "?tag=nothing", wantCodes: []string{}},
+		{query: "?tag=nothing&tag=go", wantCodes: []string{}},
+		{query: "?tag=expired", wantCodes: []string{}},
+	}
+	for storeName, newStore := range stores {
+		store := newStore(t)
+		add := newTestAddPath(store)
+		for _, body := range []string{
+			`{"url": "https://example.com/a", "alias": "a", "tags": ["web", "go"]}`,
+			`{"url": "https://example.com/b", "alias": "b", "tags": ["go", "web", "db", "go"]}`,
+			`{"url": "https://example.com/c", "alias": "c", "tags": [" go "]}`,
+			`{"url": "https://example.com/d", "alias": "d", "tags": ["docs"]}`,
+			`{"url": "https://example.com/e", "alias": "e"}`,
+		} {
+			if rec := do(add, "POST", "/add", body); rec.Code != http.StatusCreated {
+				t.Fatalf("%v: add %s: status %v: %s", storeName, body, rec.Code, rec.Body)
+			}
+		}
+		err := store.Add("x", Link{URL: "https://example.com/x", ExpiresAt: time.Now().Add(-time.Minute), Tags: []string{"expired", "go"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Run(storeName+"/stored", func(t *testing.T) {
+			link, err := store.Get("b")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{"db", "go", "web"}; !reflect.DeepEqual(link.Tags, want) {
+				t.Errorf("tags = %q, want %q", link.Tags, want)
+			}
+		})
+
+		p := &LinksPath{domain: "sho.rt", store: store}
+		for _, tt := range listTests {
+			t.Run(storeName+"/"+tt.query, func(t *testing.T) {
+				status, links := listLinks(t, p, tt.query)
+				if status != http.StatusOK {
+					t.Fatalf("status = %v", status)
+				}
+				codes := []string{}
+				for _, l := range links {
+					codes = append(codes, l.Hash)
+				}
+				if !reflect.DeepEqual(codes, tt.wantCodes) {
+					t.Errorf("codes = %q, want %q", codes, tt.wantCodes)
+				}
+			})
+		}
+
+		t.Run(storeName+"/counts", func(t *testing.T) {
+			rec := do(&TagsPath{store: store}, "GET", "/tags", "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %v: %s", rec.Code, rec.Body)
+			}
+			want := `[{"tag":"db","count":1},{"tag":"docs","count":1},{"tag":"go","count":3},{"tag":"web","count":2}]`
+			if got := rec.Body.String(); got != want+"\n" {
+				t.Errorf("got %s, want %s", got, want)
+			}
+		})
+
+		t.Run(storeName+"/removed", func(t *testing.T) {
+			if err := store.Remove("b"); err != nil {
+				t.Fatal(err)
+			}
+			_, links := listLinks(t, p, "?tag=db")
+			if len(links) != 0 {
+				t.Errorf("removed link still listed: %+v", links)
+			}
+			// A new link under the code does not inherit the old tags.
+			mustAdd(t, store, "b", "https://example.com/b2")
+			link, err := store.Get("b")
+			if err != nil || len(link.Tags) != 0 {
+				t.Errorf("re-added link = %+v, %v", link, err)
+			}
+		})
+	}
+}
+
+func TestAddPathTags(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantTags   []string
+	}{
+		{name: "sorted and deduplicated", body: `{"url": "https://example.com/", "tags": ["b", "a", "b"]}`, wantStatus: http.StatusCreated, wantTags: []string{"a", "b"}},
+		{name: "none", body: `{"url": "https://example.com/"}`, wantStatus: http.StatusCreated},
+		{name: "empty tag", body: `{"url": "https://example.com/", "tags": ["a", " "]}`, wantStatus: http.StatusBadRequest},
+		{name: "long tag", body: `{"url": "https://example.com/", "tags": ["` + strings.Repeat("x", maxTagLength+1) + `"]}`, wantStatus: http.StatusBadRequest},
+		{name: "too many", body: `{"url": "https://example.com/", "tags": ["1","2","3","4","5","6","7","8","9","10","11","12","13","14","15","16","17","18","19","20","21"]}`, wantStatus: http.StatusBadRequest},
+		{name: "not strings", body: `{"url": "https://example.com/", "tags": [1]}`, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(newTestAddPath(NewMemoryStore()), "POST", "/add", tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %v, want %v: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rec.Code != http.StatusCreated {
+				return
+			}
+			var resp struct {
+				Tags []string `json:"tags"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(resp.Tags, tt.wantTags) {
+				t.Errorf("tags = %q, want %q", resp.Tags, tt.wantTags)
+			}
+		})
+	}
+}
+
+func TestLinksPathBadTagQuery(t *testing.T) {
+	p := &LinksPath{domain: "sho.rt", store: NewMemoryStore()}
+	for _, query := range []string{"?tag=", "?tag=go&match=some"} {
+		if status, _ := listLinks(t, p, query); status != http.StatusBadRequest {
+			t.Errorf("%v: status = %v, want 400", query, status)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// WritePolicy controls how TieredStore propagates writes to its tiers.
+type WritePolicy int
+
+const (
+	// WriteThrough applies every write to the slow tier first and then to
+	// the fast tier, returning only once both have been attempted.
+	WriteThrough WritePolicy = iota
+	// WriteBack applies adds to the fast tier and propagates them to the
+	// slow tier in the background. Removes still write through.
+	WriteBack
+)
+
+func ParseWritePolicy(s string) (WritePolicy, error) {
+	switch s {
+	case "through", "write-through":
+		return WriteThrough, nil
+	case "back", "write-back":
+		return WriteBack, nil
+	}
+	return WriteThrough, fmt.Errorf("unknown write policy %q", s)
+}
+
+// TieredStore composes a fast store (e.g. a cache) in front of a durable
+// one. Reads check the fast tier first and fall back to the slow tier,
+// populating the fast tier on a miss. If the fast tier fails, requests are
+// served from the slow tier.
+type TieredStore struct {
+	fast   Store
+	slow   Store
+	policy WritePolicy
+	// pending tracks write-back operations not yet applied to the slow
+	// tier.
+	pending sync.WaitGroup
+}
+
+func (t *TieredStore) Add(shortenedURL string, link Link) error {
+	if t.policy == WriteBack {
+		// The fast tier only holds some of the links, so it cannot tell
+		// whether the code is taken; ask the slow tier before accepting
+		// the write.
+		_, err := t.slow.Get(shortenedURL)
+		if err == nil {
+			return ErrAlreadyExists
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return t.writeBack(shortenedURL, func(s Store) error {
+			return s.Add(shortenedURL, link)
+		})
+	}
+	err := t.slow.Add(shortenedURL, link)
+	if err != nil {
+		return err
+	}
+	err = t.fast.Add(shortenedURL, link)
+	if err != nil {
+		log.Printf("tiered store: unable to add %v to fast tier: %v", shortenedURL, err)
+	}
+	return nil
+}
+
+// AddBatch always writes through to the slow tier, so a large import is
+// durable before it is acknowledged.
+func (t *TieredStore) AddBatch(records []LinkRecord) []error {
+	errs := addBatch(t.slow, records)
+	for i, rec := range records {
+		if errs[i] != nil {
+			continue
+		}
+		err := t.fast.Add(rec.Hash, rec.Link)
+		if err != nil {
+			log.Printf("tiered store: unable to add %v to fast tier: %v", rec.Hash, err)
+		}
+	}
+	return errs
+}
+
+// Remove always writes through, even in write-back mode: until the slow tier
+// forgets the code, a read that misses the fast tier would copy the link
+// back into it.
+func (t *TieredStore) Remove(shortenedURL string) error {
+	err := t.slow.Remove(shortenedURL)
+	if err != nil {
+		return err
+	}
+	// The fast tier only holds entries that were written or read through
+	// it, so a miss here is expected.
+	t.fast.Remove(shortenedURL)
+	return nil
+}
+
+func (t *TieredStore) Get(shortenedURL string) (Link, error) {
+	link, err := t.fast.Get(shortenedURL)
+	if err == nil {
+		return link, nil
+	}
+	link, err = t.slow.Get(shortenedURL)
+	if err != nil {
+		return Link{}, err
+	}
+	err = t.fast.Add(shortenedURL, link)
+	if err != nil {
+		log.Printf("tiered store: unable to populate fast tier for %v: %v", shortenedURL, err)
+	}
+	return link, nil
+}
+
+// GetByURL asks the slow tier, since the fast tier may hold only some of
+// the codes for longURL.
+func (t *TieredStore) GetByURL(longURL string) ([]string, error) {
+	return t.slow.GetByURL(longURL)
+}
+
+// List pages through the slow tier, which holds every link.
+func (t *TieredStore) List(limit, offset int) ([]LinkRecord, error) {
+	return t.slow.List(limit, offset)
+}
+
+// ListTagged and TagCounts ask the slow tier, which holds every link.
+func (t *TieredStore) ListTagged(tags []string, all bool, limit, offset int) ([]LinkRecord, error) {
+	ts, ok := t.slow.(TagStore)
+	if !ok {
+		return nil, fmt.Errorf("slow tier cannot filter by tag")
+	}
+	return ts.ListTagged(tags, all, limit, offset)
+}
+
+func (t *TieredStore) TagCounts() (map[string]int, error) {
+	ts, ok := t.slow.(TagStore)
+	if !ok {
+		return nil, fmt.Errorf("slow tier cannot list tags")
+	}
+	return ts.TagCounts()
+}
+
+// Rewrite rewrites the slow tier and drops changed codes from the fast
+// tier so stale destinations are not served from it.
+func (t *TieredStore) Rewrite(fn func(code, longURL string) (string, bool, error), dryRun bool) ([]rewriteChange, error) {
+	rw, ok := t.slow.(Rewriter)
+	if !ok {
+		return nil, fmt.Errorf("slow tier cannot rewrite destinations")
+	}
+	changes, err := rw.Rewrite(fn, dryRun)
+	if err != nil || dryRun {
+		return changes, err
+	}
+	for _, c := range changes {
+		t.fast.Remove(c.Code)
+	}
+	return changes, nil
+}
+
+// Hit counts on the slow tier, which holds the durable record, and on the
+// fast tier when it holds the link so cached reads stay current.
+func (t *TieredStore) Hit(shortenedURL string) error {
+	hc, ok := t.slow.(HitCounter)
+	if !ok {
+		return fmt.Errorf("slow tier cannot count hits")
+	}
+	err := hc.Hit(shortenedURL)
+	if err != nil {
+		return err
+	}
+	if hc, ok := t.fast.(HitCounter); ok {
+		hc.Hit(shortenedURL)
+	}
+	return nil
+}
+
+// writeBack applies op to the fast tier and replays it against the slow
+// tier asynchronously. When the fast tier rejects the operation (because it
+// is down or does not hold the entry) op is applied to the slow tier
+// directly so its result is authoritative.
+func (t *TieredStore) writeBack(shortenedURL string, op func(Store) error) error {
+	err := op(t.fast)
+	if err != nil {
+		return op(t.slow)
+	}
+	t.pending.Add(1)
+	go func() {
+		defer t.pending.Done()
+		err := op(t.slow)
+		if err != nil {
+			log.Printf("tiered store: unable to write back %v to slow tier: %v", shortenedURL, err)
+		}
+	}()
+	return nil
+}
+
+// Flush waits for pending write-back operations to reach the slow tier.
+func (t *TieredStore) Flush() {
+	t.pending.Wait()
+}
+
+// WasRemoved reports tombstones from the slow tier, which sees every
+// removal.
+func (t *TieredStore) WasRemoved(shortenedURL string) (bool, error) {
+	ts, ok := t.slow.(Tombstoner)
+	if !ok {
+		return false, nil
+	}
+	return ts.WasRemoved(shortenedURL)
+}
+
+// Len reports the size of the slow tier, which holds every link.
+func (t *TieredStore) Len() (int, error) {
+	s, ok := t.slow.(Sizer)
+	if !ok {
+		return 0, fmt.Errorf("slow tier cannot report its size")
+	}
+	return s.Len()
+}
+
+func NewTieredStore(fast, slow Store, policy WritePolicy) *TieredStore {
+	return &TieredStore{
+		fast:   fast,
+		slow:   slow,
+		policy: policy,
+	}
+}
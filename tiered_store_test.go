@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// slowStore delays every Add, so write-backs are still in flight when the
+// test asks TieredStore to flush.
+type slowStore struct {
+	*MemoryStore
+	delay time.Duration
+}
+
+func (s slowStore) Add(code string, link Link) error {
+	time.Sleep(s.delay)
+	return s.MemoryStore.Add(code, link)
+}
+
+func TestTieredStore(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy WritePolicy
+		// fastDown replaces the fast tier with one that always fails.
+		fastDown bool
+		run      func(t *testing.T, ts *TieredStore, fast, slow *MemoryStore)
+	}{
+		{
+			name:   "write-through reaches both tiers",
+			policy: WriteThrough,
+			run: func(t *testing.T, ts *TieredStore, fast, slow *MemoryStore) {
+				mustAdd(t, ts, "abc", "https://example.com/")
+				for name, s := range map[string]*MemoryStore{"fast": fast, "slow": slow} {
+					if _, err := s.Get("abc"); err != nil {
+						t.Errorf("%v tier: %v", name, err)
+					}
+				}
+			},
+		},
+		{
+			name:   "write-back reaches the slow tier after Flush",
+			policy: WriteBack,
+			run: func(t *testing.T, ts *TieredStore, fast, slow *MemoryStore) {
+				mustAdd(t, ts, "abc", "https://example.com/")
+				ts.Flush()
+				if _, err := slow.Get("abc"); err != nil {
+					t.Errorf("slow tier: %v", err)
+				}
+			},
+		},
+		{
+			name:   "read miss populates the fast tier",
+			policy: WriteThrough,
+			run: func(t *testing.T, ts *TieredStore, fast, slow *MemoryStore) {
+				slow.Add("abc", Link{URL: "https://example.com/"})
+				if _, err := ts.Get("abc"); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := fast.Get("abc"); err != nil {
+					t.Errorf("fast tier: %v", err)
+				}
+			},
+		},
+		{
+			name:     "fast tier down falls back to the slow tier",
+			policy:   WriteThrough,
+			fastDown: true,
+			run: func(t *testing.T, ts *TieredStore, fast, slow *MemoryStore) {
+				mustAdd(t, ts, "abc", "https://example.com/")
+				link, err := ts.Get("abc")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if link.URL != "https://example.com/" {
+					t.Errorf("url = %q", link.URL)
+				}
+			},
+		},
+		{
+			name:     "write-back with fast tier down writes the slow tier",
+			policy:   WriteBack,
+			fastDown: true,
+			run: func(t *testing.T, ts *TieredStore, fast, slow *MemoryStore) {
+				mustAdd(t, ts, "abc", "https://example.com/")
+				if _, err := slow.Get("abc"); err != nil {
+					t.Errorf("slow tier: %v", err)
+				}
+			},
+		},
+		{
+			name:   "write-back rejects a code only the slow tier holds",
+			policy: WriteBack,
+			run: func(t *testing.T, ts *TieredStore, fast, slow *MemoryStore) {
+				slow.Add("abc", Link{URL: "https://example.com/"})
+				err := ts.Add("abc", Link{URL: "https://example.org/"})
+				if !errors.Is(err, ErrAlreadyExists) {
+					t.Fatalf("error = %v, want %v", err, ErrAlreadyExists)
+				}
+				ts.Flush()
+				link, _ := slow.Get("abc")
+				if link.URL != "https://example.com/" {
+					t.Errorf("slow tier url = %q, want the original", link.URL)
+				}
+			},
+		},
+		{
+			name:   "remove clears both tiers",
+			policy: WriteThrough,
+			run: func(t *testing.T, ts *TieredStore, fast, slow *MemoryStore) {
+				mustAdd(t, ts, "abc", "https://example.com/")
+				if err := ts.Remove("abc"); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := ts.Get("abc"); !errors.Is(err, ErrNotFound) {
+					t.Errorf("error = %v, want %v", err, ErrNotFound)
+				}
+			},
+		},
+		{
+			name:   "write-back remove is not undone by a read",
+			policy: WriteBack,
+			run: func(t *testing.T, ts *TieredStore, fast, slow *MemoryStore) {
+				mustAdd(t, ts, "abc", "https://example.com/")
+				ts.Flush()
+				if err := ts.Remove("abc"); err != nil {
+					t.Fatal(err)
+				}
+				// A read right after the remove must not copy the link
+				// back from the slow tier into the fast one.
+				if link, err := ts.Get("abc"); !errors.Is(err, ErrNotFound) {
+					t.Errorf("got %+v, %v after remove, want %v", link, err, ErrNotFound)
+				}
+				ts.Flush()
+				for name, s := range map[string]*MemoryStore{"fast": fast, "slow": slow} {
+					if _, err := s.Get("abc"); !errors.Is(err, ErrNotFound) {
+						t.Errorf("%v tier: error = %v, want %v", name, err, ErrNotFound)
+					}
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fast, slow := NewMemoryStore(), NewMemoryStore()
+			var fastTier Store = fast
+			if tt.fastDown {
+				fastTier = downStore{}
+			}
+			ts := NewTieredStore(fastTier, slow, tt.policy)
+			tt.run(t, ts, fast, slow)
+		})
+	}
+}
+
+func TestTieredStoreFlushWaitsForWriteBacks(t *testing.T) {
+	slow := slowStore{MemoryStore: NewMemoryStore(), delay: 50 * time.Millisecond}
+	ts := NewTieredStore(NewMemoryStore(), slow, WriteBack)
+	const n = 20
+	for i := 0; i < n; i++ {
+		mustAdd(t, ts, fmt.Sprintf("c%v", i), "https://example.com/")
+	}
+	ts.Flush()
+	for i := 0; i < n; i++ {
+		if _, err := slow.Get(fmt.Sprintf("c%v", i)); err != nil {
+			t.Errorf("c%v did not reach the slow tier: %v", i, err)
+		}
+	}
+}